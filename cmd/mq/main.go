@@ -35,6 +35,17 @@ func main() {
 			Usage:  "stomp server password",
 			EnvVar: "STOMP_PASSWORD",
 		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "stomp server connect and receipt timeout",
+			Value:  time.Second * 30,
+			EnvVar: "STOMP_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "client-id",
+			Usage:  "stomp client id used by durable subscriptions",
+			EnvVar: "STOMP_CLIENT_ID",
+		},
 		cli.IntFlag{
 			Name:   "level",
 			Usage:  "logging level",
@@ -62,6 +73,19 @@ func main() {
 					Name:  "ttl",
 					Usage: "sends the message with a ttl",
 				},
+				cli.DurationFlag{
+					Name:  "delay",
+					Usage: "sends the message with a delivery delay",
+				},
+				cli.StringFlag{
+					Name:  "group",
+					Usage: "sends the message with a message group",
+				},
+				cli.IntFlag{
+					Name:  "priority",
+					Usage: "sends the message with a priority from 0 to 9",
+					Value: -1,
+				},
 				cli.StringSliceFlag{
 					Name:  "H, header",
 					Usage: "sends the message with a custom header",
@@ -99,6 +123,22 @@ func main() {
 					Name:  "ack",
 					Usage: "subscribes with ack settings",
 				},
+				cli.DurationFlag{
+					Name:  "ack-timeout",
+					Usage: "subscribes with an ack timeout",
+				},
+				cli.BoolFlag{
+					Name:  "exclusive",
+					Usage: "subscribes as an exclusive consumer",
+				},
+				cli.IntFlag{
+					Name:  "concurrency",
+					Usage: "subscribes with a pool of concurrent handlers",
+				},
+				cli.StringFlag{
+					Name:  "durable",
+					Usage: "subscribes with a durable subscription name",
+				},
 			},
 		},
 		comandServe,
@@ -126,7 +166,9 @@ func createClient(c *cli.Context) (*stomp.Client, error) {
 	)
 	logger.SetLogger(logs)
 
-	cli, err := stomp.Dial(target)
+	cli, err := stomp.Dial(target,
+		stomp.WithTimeout(c.GlobalDuration("timeout")),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +178,7 @@ func createClient(c *cli.Context) (*stomp.Client, error) {
 
 		username = c.GlobalString("username")
 		password = c.GlobalString("password")
+		clientID = c.GlobalString("client-id")
 	)
 
 	if username != "" || password != "" {
@@ -144,6 +187,12 @@ func createClient(c *cli.Context) (*stomp.Client, error) {
 		)
 	}
 
+	if clientID != "" {
+		opts = append(opts,
+			stomp.WithClientID(clientID),
+		)
+	}
+
 	if err := cli.Connect(opts...); err != nil {
 		return nil, err
 	}
@@ -173,6 +222,15 @@ func send(c *cli.Context) (err error) {
 		exp := time.Now().Add(ttl).Unix()
 		opts = append(opts, stomp.WithExpires(exp))
 	}
+	if delay := c.Duration("delay"); delay != 0 {
+		opts = append(opts, stomp.WithDelay(delay))
+	}
+	if group := c.String("group"); group != "" {
+		opts = append(opts, stomp.WithGroup(group))
+	}
+	if priority := c.Int("priority"); priority != -1 {
+		opts = append(opts, stomp.WithPriority(priority))
+	}
 	if c.Bool("receipt") {
 		opts = append(opts, stomp.WithReceipt())
 	}
@@ -211,6 +269,18 @@ func subscribe(c *cli.Context) (err error) {
 	if ack := c.String("ack"); ack != "" {
 		opts = append(opts, stomp.WithAck(ack))
 	}
+	if timeout := c.Duration("ack-timeout"); timeout != 0 {
+		opts = append(opts, stomp.WithAckTimeout(timeout))
+	}
+	if c.Bool("exclusive") {
+		opts = append(opts, stomp.WithExclusive())
+	}
+	if n := c.Int("concurrency"); n != 0 {
+		opts = append(opts, stomp.WithConcurrency(n))
+	}
+	if durable := c.String("durable"); durable != "" {
+		opts = append(opts, stomp.WithDurable(durable))
+	}
 
 	handler := func(m *stomp.Message) {
 		log.Println(m)
@@ -231,5 +301,11 @@ func subscribe(c *cli.Context) (err error) {
 	case <-client.Done():
 	}
 
+	// a durable subscription is not unsubscribed, which would
+	// delete it, so that messages are buffered until the client
+	// subscribes again.
+	if c.String("durable") != "" {
+		return nil
+	}
 	return client.Unsubscribe(id)
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"time"
 
 	"github.com/tidwall/redlog"
 	"github.com/urfave/cli"
@@ -63,6 +64,108 @@ var comandServe = cli.Command{
 			Usage:  "stomp lets encrypt cache directory",
 			EnvVar: "STOMP_LETS_ENCRYPT_DIR",
 		},
+		cli.DurationFlag{
+			Name:   "heartbeat-send",
+			Usage:  "stomp minimum heart-beat send interval",
+			Value:  time.Second * 30,
+			EnvVar: "STOMP_HEARTBEAT_SEND",
+		},
+		cli.DurationFlag{
+			Name:   "heartbeat-recv",
+			Usage:  "stomp desired heart-beat receive interval",
+			Value:  time.Second * 30,
+			EnvVar: "STOMP_HEARTBEAT_RECV",
+		},
+		cli.DurationFlag{
+			Name:   "heartbeat-max",
+			Usage:  "stomp maximum negotiated heart-beat interval",
+			Value:  time.Minute * 5,
+			EnvVar: "STOMP_HEARTBEAT_MAX",
+		},
+		cli.IntFlag{
+			Name:   "max-frame-size",
+			Usage:  "stomp maximum frame size in bytes",
+			Value:  1 << 20,
+			EnvVar: "STOMP_MAX_FRAME_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "max-headers",
+			Usage:  "stomp maximum number of frame headers",
+			Value:  1000,
+			EnvVar: "STOMP_MAX_HEADERS",
+		},
+		cli.IntFlag{
+			Name:   "max-header-size",
+			Usage:  "stomp maximum header length in bytes",
+			Value:  10 << 10,
+			EnvVar: "STOMP_MAX_HEADER_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "dispatch",
+			Usage:  "stomp queue dispatch strategy (round-robin, least-pending, priority)",
+			Value:  "round-robin",
+			EnvVar: "STOMP_DISPATCH",
+		},
+		cli.IntFlag{
+			Name:   "queue-max-length",
+			Usage:  "stomp maximum number of pending messages per queue",
+			EnvVar: "STOMP_QUEUE_MAX_LENGTH",
+		},
+		cli.IntFlag{
+			Name:   "queue-max-bytes",
+			Usage:  "stomp maximum size in bytes of pending messages per queue",
+			EnvVar: "STOMP_QUEUE_MAX_BYTES",
+		},
+		cli.StringFlag{
+			Name:   "queue-overflow",
+			Usage:  "stomp queue overflow policy (reject, drop-oldest, dead-letter)",
+			Value:  "reject",
+			EnvVar: "STOMP_QUEUE_OVERFLOW",
+		},
+		cli.IntFlag{
+			Name:   "retain-max-count",
+			Usage:  "stomp maximum number of messages retained per topic",
+			Value:  1000,
+			EnvVar: "STOMP_RETAIN_MAX_COUNT",
+		},
+		cli.DurationFlag{
+			Name:   "retain-max-age",
+			Usage:  "stomp maximum age of messages retained per topic",
+			EnvVar: "STOMP_RETAIN_MAX_AGE",
+		},
+		cli.IntFlag{
+			Name:   "durable-max-pending",
+			Usage:  "stomp maximum number of messages buffered per offline durable subscription",
+			Value:  1000,
+			EnvVar: "STOMP_DURABLE_MAX_PENDING",
+		},
+		cli.IntFlag{
+			Name:   "send-buffer",
+			Usage:  "stomp outbound message buffer size per session",
+			Value:  100,
+			EnvVar: "STOMP_SEND_BUFFER",
+		},
+		cli.StringFlag{
+			Name:   "slow-consumer",
+			Usage:  "stomp slow consumer policy (block, drop-oldest, drop-newest, disconnect)",
+			Value:  "block",
+			EnvVar: "STOMP_SLOW_CONSUMER",
+		},
+		cli.IntFlag{
+			Name:   "max-redeliveries",
+			Usage:  "stomp maximum message redeliveries",
+			EnvVar: "STOMP_MAX_REDELIVERIES",
+		},
+		cli.StringFlag{
+			Name:   "policy",
+			Usage:  "stomp destination access policy file",
+			EnvVar: "STOMP_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "storage",
+			Usage:  "stomp persistent storage path",
+			EnvVar: "STOMP_STORAGE",
+		},
 		cli.StringFlag{
 			Name:   "base, b",
 			Usage:  "stomp server base",
@@ -84,6 +187,24 @@ func serve(c *cli.Context) error {
 		route = c.String("path")
 		cert  = c.String("cert")
 		key   = c.String("key")
+		store = c.String("storage")
+		acl   = c.String("policy")
+		redel = c.Int("max-redeliveries")
+		frame = c.Int("max-frame-size")
+		hdrs  = c.Int("max-headers")
+		hsize = c.Int("max-header-size")
+		sendb = c.Int("send-buffer")
+		slow  = c.String("slow-consumer")
+		disp  = c.String("dispatch")
+		qlen  = c.Int("queue-max-length")
+		qsize = c.Int("queue-max-bytes")
+		qover = c.String("queue-overflow")
+		rcnt  = c.Int("retain-max-count")
+		rage  = c.Duration("retain-max-age")
+		dlim  = c.Int("durable-max-pending")
+		sendc = c.Duration("heartbeat-send")
+		recvc = c.Duration("heartbeat-recv")
+		maxhb = c.Duration("heartbeat-max")
 
 		acme  = c.Bool("lets-encrypt")
 		host  = c.String("lets-encrypt-host")
@@ -92,12 +213,70 @@ func serve(c *cli.Context) error {
 	)
 
 	var opts []server.Option
+	opts = append(opts,
+		server.WithHeartbeat(sendc, recvc),
+		server.WithMaxHeartbeat(maxhb),
+		server.WithMaxFrameSize(frame),
+		server.WithMaxHeaders(hdrs),
+		server.WithMaxHeaderSize(hsize),
+		server.WithSendBuffer(sendb),
+		server.WithRetention(rcnt, rage),
+		server.WithDurableLimit(dlim),
+	)
 	if user != "" || pass != "" {
 		opts = append(opts,
 			server.WithCredentials(user, pass),
 		)
 	}
 
+	slowc, err := server.ParseSlowConsumer(slow)
+	if err != nil {
+		return err
+	}
+	opts = append(opts,
+		server.WithSlowConsumer(slowc),
+	)
+
+	strategy, err := server.ParseDispatch(disp)
+	if err != nil {
+		return err
+	}
+	opts = append(opts,
+		server.WithDispatch(strategy),
+	)
+
+	overflow, err := server.ParseOverflow(qover)
+	if err != nil {
+		return err
+	}
+	opts = append(opts,
+		server.WithQueueLimit(server.QueueLimit{
+			MaxLength: qlen,
+			MaxBytes:  qsize,
+			Overflow:  overflow,
+		}),
+	)
+
+	if redel != 0 {
+		opts = append(opts,
+			server.WithMaxRedeliveries(redel),
+		)
+	}
+	if acl != "" {
+		policy, err := server.LoadPolicy(acl)
+		if err != nil {
+			return err
+		}
+		opts = append(opts,
+			server.WithPolicy(policy),
+		)
+	}
+	if store != "" {
+		opts = append(opts,
+			server.WithStorage(store),
+		)
+	}
+
 	logs := redlog.New(os.Stderr)
 	logs.SetLevel(
 		c.GlobalInt("level"),
@@ -105,9 +284,15 @@ func serve(c *cli.Context) error {
 	logger.SetLogger(logs)
 	logger.Noticef("stomp: starting server")
 
-	server := server.NewServer(opts...)
+	server, err := server.Open(opts...)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
 	http.HandleFunc(path.Join("/", base, "meta/sessions"), server.HandleSessions)
 	http.HandleFunc(path.Join("/", base, "meta/destinations"), server.HandleDests)
+	http.HandleFunc(path.Join("/", base, "meta/durables"), server.HandleDurables)
+	http.HandleFunc(path.Join("/", base, "meta/metrics"), server.HandleMetrics)
 	http.Handle(path.Join("/", base, route), server)
 
 	go func() {
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/drone/mq/stomp"
+)
+
+// Dispatch defines how queue messages are distributed to subscribers.
+type Dispatch int
+
+// Queue dispatch strategies.
+const (
+	// DispatchRoundRobin delivers to each subscriber in turn.
+	DispatchRoundRobin Dispatch = iota
+
+	// DispatchLeastPending delivers to the subscriber with the fewest
+	// messages pending acknowledgement. Subscribers using automatic
+	// acknowledgement never have pending messages.
+	DispatchLeastPending
+
+	// DispatchPriority delivers to the subscriber with the highest
+	// consumer priority.
+	DispatchPriority
+)
+
+// ParseDispatch returns the dispatch strategy with the given name:
+// round-robin, least-pending or priority.
+func ParseDispatch(name string) (Dispatch, error) {
+	switch name {
+	case "round-robin":
+		return DispatchRoundRobin, nil
+	case "least-pending":
+		return DispatchLeastPending, nil
+	case "priority":
+		return DispatchPriority, nil
+	}
+	return 0, fmt.Errorf("stomp: invalid dispatch strategy %q", name)
+}
+
+// dispatcher selects the queue subscriber that receives the next
+// message. Subscribers are scanned starting after the previously
+// selected subscriber, so that ties are broken in round-robin order.
+type dispatcher struct {
+	pos int
+
+	// prefer returns true if subscriber a should be selected
+	// over subscriber b. If nil, the first eligible subscriber
+	// is selected.
+	prefer func(a, b *subscription) bool
+}
+
+func newDispatcher(strategy Dispatch) *dispatcher {
+	d := new(dispatcher)
+	switch strategy {
+	case DispatchLeastPending:
+		d.prefer = func(a, b *subscription) bool {
+			return a.Pending() < b.Pending()
+		}
+	case DispatchPriority:
+		d.prefer = func(a, b *subscription) bool {
+			return a.priority > b.priority
+		}
+	}
+	return d
+}
+
+// next returns the next subscriber eligible to receive the
+// message, or nil if no subscriber is eligible.
+func (d *dispatcher) next(subs []*subscription, m *stomp.Message) *subscription {
+	best := -1
+	for i := range subs {
+		j := (d.pos + i) % len(subs)
+		if !accepts(subs[j], m) {
+			continue
+		}
+		if d.prefer == nil {
+			best = j
+			break
+		}
+		if best == -1 || d.prefer(subs[j], subs[best]) {
+			best = j
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	d.pos = best + 1
+	return subs[best]
+}
+
+// accepts returns true if the subscriber matches the message
+// selector and has not reached its prefetch limit.
+func accepts(sub *subscription, m *stomp.Message) bool {
+	if sub.selector != nil {
+		if ok, _ := sub.selector.Eval(m.Header); !ok {
+			return false
+		}
+	}
+	return sub.prefetch == 0 || sub.prefetch != sub.Pending()
+}
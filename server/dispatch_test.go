@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/drone/mq/stomp"
+	"github.com/drone/mq/stomp/selector"
+)
+
+func Test_dispatcher_roundRobin(t *testing.T) {
+	a, b, c := &subscription{}, &subscription{}, &subscription{}
+	subs := []*subscription{a, b, c}
+	m := stomp.NewMessage()
+
+	d := newDispatcher(DispatchRoundRobin)
+	for i, want := range []*subscription{a, b, c, a, b, c} {
+		if got := d.next(subs, m); got != want {
+			t.Errorf("Want subscriber %d selected in round-robin order", i%3)
+		}
+	}
+}
+
+func Test_dispatcher_leastPending(t *testing.T) {
+	a, b, c := &subscription{}, &subscription{}, &subscription{}
+	a.pending = 2
+	b.pending = 1
+	c.pending = 1
+	subs := []*subscription{a, b, c}
+	m := stomp.NewMessage()
+
+	// subscribers with the same number of pending
+	// messages are selected in round-robin order.
+	d := newDispatcher(DispatchLeastPending)
+	for _, want := range []*subscription{b, c, b} {
+		if got := d.next(subs, m); got != want {
+			t.Errorf("Want subscriber with the fewest pending messages selected")
+		}
+	}
+	a.pending = 0
+	if got := d.next(subs, m); got != a {
+		t.Errorf("Want subscriber with the fewest pending messages selected")
+	}
+}
+
+func Test_dispatcher_priority(t *testing.T) {
+	a, b, c := &subscription{}, &subscription{}, &subscription{}
+	b.priority = 10
+	c.priority = 10
+	subs := []*subscription{a, b, c}
+	m := stomp.NewMessage()
+
+	d := newDispatcher(DispatchPriority)
+	for _, want := range []*subscription{b, c, b} {
+		if got := d.next(subs, m); got != want {
+			t.Errorf("Want subscriber with the highest priority selected")
+		}
+	}
+
+	// the high priority subscribers reach their prefetch
+	// limit and the low priority subscriber is selected.
+	b.prefetch, b.pending = 1, 1
+	c.prefetch, c.pending = 1, 1
+	if got := d.next(subs, m); got != a {
+		t.Errorf("Want lower priority subscriber selected when others are busy")
+	}
+}
+
+func Test_dispatcher_selector(t *testing.T) {
+	a, b := &subscription{}, &subscription{}
+	a.selector, _ = selector.Parse([]byte("platform == 'windows'"))
+	subs := []*subscription{a, b}
+
+	m := stomp.NewMessage()
+	m.Header.Add([]byte("platform"), []byte("linux"))
+
+	d := newDispatcher(DispatchRoundRobin)
+	for i := 0; i < 2; i++ {
+		if got := d.next(subs, m); got != b {
+			t.Errorf("Want subscriber matching the selector selected")
+		}
+	}
+	if got := d.next(nil, m); got != nil {
+		t.Errorf("Want nil subscriber when there are no subscribers")
+	}
+}
+
+func Test_ParseDispatch(t *testing.T) {
+	var tests = map[string]Dispatch{
+		"round-robin":   DispatchRoundRobin,
+		"least-pending": DispatchLeastPending,
+		"priority":      DispatchPriority,
+	}
+	for name, want := range tests {
+		if got, err := ParseDispatch(name); err != nil || got != want {
+			t.Errorf("Want strategy %s parsed", name)
+		}
+	}
+	if _, err := ParseDispatch("invalid"); err == nil {
+		t.Errorf("Want error parsing invalid strategy")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drone/mq/stomp"
+	"github.com/drone/mq/stomp/selector"
+)
+
+var (
+	errDurableTopic    = errors.New("stomp: durable subscriptions require a topic")
+	errDurableClientID = errors.New("stomp: durable subscriptions require a client-id")
+)
+
+// default maximum number of messages buffered for
+// an offline durable subscription.
+const defaultDurablePending = 1000
+
+// durable is a named topic subscription that outlives the session.
+// Messages published while the subscriber is offline are buffered in
+// memory and delivered when the subscriber reconnects.
+type durable struct {
+	sync.Mutex
+
+	key  string
+	dest string
+
+	// sub is the active subscription, or nil if the
+	// subscriber is offline.
+	sub *subscription
+
+	// selector filters messages buffered while
+	// the subscriber is offline.
+	selector *selector.Selector
+
+	msgs  []*stomp.Message
+	since time.Time
+}
+
+// durableKey returns the key identifying the durable subscription,
+// composed of the login, client id and subscription name. The login
+// prevents a user from resuming the durable subscription of another
+// user with the same client id.
+func durableKey(login string, clientID, name []byte) string {
+	return login + ":" + string(clientID) + ":" + string(name)
+}
+
+// buffer appends the message to the buffered messages. If the limit
+// is exceeded the oldest message is discarded and true is returned.
+func (d *durable) buffer(m *stomp.Message, limit int) (dropped bool) {
+	d.Lock()
+	d.msgs = append(d.msgs, m)
+	if limit != 0 && len(d.msgs) > limit {
+		d.msgs[0].Release()
+		d.msgs = append(d.msgs[:0], d.msgs[1:]...)
+		dropped = true
+	}
+	d.Unlock()
+	return
+}
+
+// flush removes and returns the buffered messages.
+func (d *durable) flush() (msgs []*stomp.Message) {
+	d.Lock()
+	msgs = d.msgs
+	d.msgs = nil
+	d.Unlock()
+	return
+}
+
+// pending returns the number of buffered messages.
+func (d *durable) pending() (n int) {
+	d.Lock()
+	n = len(d.msgs)
+	d.Unlock()
+	return
+}
+
+// release discards the buffered messages.
+func (d *durable) release() {
+	for _, m := range d.flush() {
+		m.Release()
+	}
+}
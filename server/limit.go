@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errQueueFull is returned when a message is sent to a queue
+// that has reached its maximum length or size.
+var errQueueFull = errors.New("stomp: queue limit exceeded")
+
+const reasonQueueFull = "queue limit exceeded"
+
+// Overflow defines how messages are handled when a queue reaches
+// its maximum length or size.
+type Overflow int
+
+// Queue overflow policies.
+const (
+	// OverflowReject rejects the message and sends an error to the
+	// producer.
+	OverflowReject Overflow = iota
+
+	// OverflowDropOldest discards the earliest published messages,
+	// including scheduled messages, to make room for the message.
+	OverflowDropOldest
+
+	// OverflowDeadLetter routes the message to the dead-letter queue.
+	OverflowDeadLetter
+)
+
+// ParseOverflow returns the overflow policy with the given name:
+// reject, drop-oldest or dead-letter.
+func ParseOverflow(name string) (Overflow, error) {
+	switch name {
+	case "reject":
+		return OverflowReject, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "dead-letter":
+		return OverflowDeadLetter, nil
+	}
+	return 0, fmt.Errorf("stomp: invalid overflow policy %q", name)
+}
+
+// QueueLimit defines the maximum depth of a queue and the policy
+// applied when a message is sent to a queue that is full.
+type QueueLimit struct {
+	// MaxLength is the maximum number of pending messages. A value
+	// of zero indicates the length is unlimited.
+	MaxLength int
+
+	// MaxBytes is the maximum size in bytes of the pending message
+	// bodies. A value of zero indicates the size is unlimited.
+	MaxBytes int
+
+	// Overflow is the policy applied when the queue is full.
+	Overflow Overflow
+}
+
+// queueLimit is a queue limit applied to destinations
+// matching the pattern.
+type queueLimit struct {
+	pattern string
+	limit   QueueLimit
+}
+
+// limit returns the limit for the named queue. Limits configured for
+// a matching destination pattern take precedence over the default.
+func (r *router) limit(dest string) QueueLimit {
+	for _, l := range r.limits {
+		if match(l.pattern, dest) {
+			return l.limit
+		}
+	}
+	return r.defaultLimit
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/mq/stomp"
+)
+
+func TestQueueLimit(t *testing.T) {
+	var tests = []struct {
+		limit   QueueLimit
+		err     error
+		pending []string
+		dlq     int
+	}{
+		{
+			limit:   QueueLimit{MaxLength: 2, Overflow: OverflowReject},
+			err:     errQueueFull,
+			pending: []string{"1", "2"},
+		},
+		{
+			limit:   QueueLimit{MaxLength: 2, Overflow: OverflowDropOldest},
+			pending: []string{"2", "3"},
+		},
+		{
+			limit:   QueueLimit{MaxBytes: 2, Overflow: OverflowDropOldest},
+			pending: []string{"2", "3"},
+		},
+		{
+			limit:   QueueLimit{MaxLength: 2, Overflow: OverflowDeadLetter},
+			pending: []string{"1", "2"},
+			dlq:     1,
+		},
+	}
+
+	for _, test := range tests {
+		router := newRouter()
+		router.defaultLimit = test.limit
+
+		var err error
+		for _, body := range []string{"1", "2", "3"} {
+			m := stomp.NewMessage()
+			m.Method = stomp.MethodSend
+			m.Dest = []byte("/queue/test")
+			m.Body = []byte(body)
+			err = router.publish(m)
+		}
+		if err != test.err {
+			t.Errorf("Want error %v publishing to a full queue, got %v", test.err, err)
+		}
+
+		q := router.destinations["/queue/test"].(*queue)
+		var pending []string
+		for _, m := range pendingMessages(q) {
+			pending = append(pending, string(m.Body))
+		}
+		if len(pending) != len(test.pending) {
+			t.Errorf("Want pending messages %v, got %v", test.pending, pending)
+			continue
+		}
+		for i := range pending {
+			if pending[i] != test.pending[i] {
+				t.Errorf("Want pending messages %v, got %v", test.pending, pending)
+				break
+			}
+		}
+
+		var dlq int
+		if h, ok := router.destinations["/queue/DLQ.test"]; ok {
+			dlq = h.pending()
+		}
+		if dlq != test.dlq {
+			t.Errorf("Want %d messages routed to the dead-letter queue, got %d", test.dlq, dlq)
+		}
+	}
+}
+
+func TestQueueLimitDropOldest(t *testing.T) {
+	due := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+
+	var tests = []struct {
+		header  []byte
+		value   []byte
+		pending []string
+	}{
+		// the first message has a lower priority and is placed
+		// behind the second message, but is dropped first.
+		{stomp.HeaderPriority, []byte("0"), []string{"2", "3"}},
+		// the first message is scheduled and is dropped
+		// before the pending messages.
+		{stomp.HeaderDeliverAt, strconv.AppendInt(nil, due, 10), []string{"2", "3"}},
+	}
+
+	for _, test := range tests {
+		router := newRouter()
+		router.defaultLimit = QueueLimit{MaxLength: 2, Overflow: OverflowDropOldest}
+
+		for _, body := range []string{"1", "2", "3"} {
+			m := stomp.NewMessage()
+			m.Method = stomp.MethodSend
+			m.Dest = []byte("/queue/test")
+			m.Body = []byte(body)
+			if body == "1" {
+				m.Header.Add(test.header, test.value)
+			}
+			if err := router.publish(m); err != nil {
+				t.Errorf("Want oldest message dropped, got error %s", err)
+			}
+		}
+
+		q := router.destinations["/queue/test"].(*queue)
+		var pending []string
+		for _, m := range pendingMessages(q) {
+			pending = append(pending, string(m.Body))
+		}
+		if strings.Join(pending, ",") != strings.Join(test.pending, ",") {
+			t.Errorf("Want pending messages %v, got %v", test.pending, pending)
+		}
+		if len(q.sched) != 0 {
+			t.Errorf("Want scheduled message dropped, got %d scheduled", len(q.sched))
+		}
+		if len(q.arrival) != len(test.pending) {
+			t.Errorf("Want arrival order of %d messages, got %d", len(test.pending), len(q.arrival))
+		}
+	}
+}
+
+func TestQueueLimitFor(t *testing.T) {
+	router := newRouter()
+	router.defaultLimit = QueueLimit{MaxLength: 10}
+	router.limits = []queueLimit{
+		{pattern: "/queue/builds.*", limit: QueueLimit{MaxLength: 100}},
+	}
+
+	if got := router.limit("/queue/builds.linux").MaxLength; got != 100 {
+		t.Errorf("Want limit matching the destination pattern, got %d", got)
+	}
+	if got := router.limit("/queue/deploys").MaxLength; got != 10 {
+		t.Errorf("Want default limit when no pattern matches, got %d", got)
+	}
+}
+
+func TestParseOverflow(t *testing.T) {
+	var tests = map[string]Overflow{
+		"reject":      OverflowReject,
+		"drop-oldest": OverflowDropOldest,
+		"dead-letter": OverflowDeadLetter,
+	}
+	for name, want := range tests {
+		if got, err := ParseOverflow(name); err != nil || got != want {
+			t.Errorf("Want overflow policy %s parsed", name)
+		}
+	}
+	if _, err := ParseOverflow("invalid"); err == nil {
+		t.Errorf("Want error parsing invalid overflow policy")
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// message counters tracked for each destination.
+const (
+	statPublished = iota
+	statDelivered
+	statAcked
+	statNacked
+	statExpired
+	statDropped
+	statLen
+)
+
+// stats tracks message counters for a single destination.
+type stats struct {
+	count [statLen]uint64
+}
+
+// incr increments the named counter. It is safe to call incr on a
+// nil stats, in which case the counter is ignored.
+func (s *stats) incr(i int) {
+	if s != nil {
+		atomic.AddUint64(&s.count[i], 1)
+	}
+}
+
+// get returns the named counter value.
+func (s *stats) get(i int) uint64 {
+	return atomic.LoadUint64(&s.count[i])
+}
+
+// metrics tracks message counters for all destinations. Counters are
+// retained when a destination is recycled to ensure they are monotonic.
+type metrics struct {
+	sync.Mutex
+	dests map[string]*stats
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		dests: make(map[string]*stats),
+	}
+}
+
+// dest returns the counters for the named destination.
+func (m *metrics) dest(name string) *stats {
+	name = label(name)
+	m.Lock()
+	s, ok := m.dests[name]
+	if !ok {
+		s = new(stats)
+		m.dests[name] = s
+	}
+	m.Unlock()
+	return s
+}
+
+// gauge is a point-in-time measurement for a single destination.
+type gauge struct {
+	pending     int
+	inflight    int
+	subscribers int
+}
+
+var counterDesc = [statLen]struct {
+	name string
+	help string
+}{
+	statPublished: {"mq_messages_published_total", "Total number of messages published."},
+	statDelivered: {"mq_messages_delivered_total", "Total number of messages delivered to subscribers."},
+	statAcked:     {"mq_messages_acked_total", "Total number of messages acknowledged."},
+	statNacked:    {"mq_messages_nacked_total", "Total number of messages negatively acknowledged."},
+	statExpired:   {"mq_messages_expired_total", "Total number of messages expired before delivery."},
+	statDropped:   {"mq_messages_dropped_total", "Total number of messages dropped when the queue is full."},
+}
+
+// writeMetrics writes the metrics in the prometheus text format.
+func (r *router) writeMetrics(w io.Writer) {
+	gauges := map[string]*gauge{}
+
+	r.RLock()
+	sessions := len(r.sessions)
+	handlers := make([]handler, 0, len(r.destinations))
+	for _, h := range r.destinations {
+		handlers = append(handlers, h)
+	}
+	for sess := range r.sessions {
+		sess.Lock()
+		for _, m := range sess.ack {
+			dest := label(string(m.Dest))
+			g, ok := gauges[dest]
+			if !ok {
+				g = new(gauge)
+				gauges[dest] = g
+			}
+			g.inflight++
+		}
+		sess.Unlock()
+	}
+	r.RUnlock()
+
+	for _, h := range handlers {
+		dest := label(h.destination())
+		g, ok := gauges[dest]
+		if !ok {
+			g = new(gauge)
+			gauges[dest] = g
+		}
+		g.pending += h.pending()
+		g.subscribers += h.subscribers()
+	}
+
+	r.metrics.Lock()
+	counters := make(map[string]*stats, len(r.metrics.dests))
+	for dest, s := range r.metrics.dests {
+		counters[dest] = s
+	}
+	r.metrics.Unlock()
+
+	for i, desc := range counterDesc {
+		fmt.Fprintf(w, "# HELP %s %s\n", desc.name, desc.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", desc.name)
+		for _, dest := range sortedKeys(counters) {
+			fmt.Fprintf(w, "%s{destination=\"%s\"} %d\n", desc.name, escapeLabel(dest), counters[dest].get(i))
+		}
+	}
+
+	var dests []string
+	for dest := range gauges {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	fmt.Fprintln(w, "# HELP mq_messages_pending Number of messages waiting to be delivered.")
+	fmt.Fprintln(w, "# TYPE mq_messages_pending gauge")
+	for _, dest := range dests {
+		fmt.Fprintf(w, "mq_messages_pending{destination=\"%s\"} %d\n", escapeLabel(dest), gauges[dest].pending)
+	}
+	fmt.Fprintln(w, "# HELP mq_messages_inflight Number of messages delivered and waiting to be acknowledged.")
+	fmt.Fprintln(w, "# TYPE mq_messages_inflight gauge")
+	for _, dest := range dests {
+		fmt.Fprintf(w, "mq_messages_inflight{destination=\"%s\"} %d\n", escapeLabel(dest), gauges[dest].inflight)
+	}
+	fmt.Fprintln(w, "# HELP mq_subscribers Number of active subscriptions.")
+	fmt.Fprintln(w, "# TYPE mq_subscribers gauge")
+	for _, dest := range dests {
+		fmt.Fprintf(w, "mq_subscribers{destination=\"%s\"} %d\n", escapeLabel(dest), gauges[dest].subscribers)
+	}
+	fmt.Fprintln(w, "# HELP mq_sessions Number of active sessions.")
+	fmt.Fprintln(w, "# TYPE mq_sessions gauge")
+	fmt.Fprintf(w, "mq_sessions %d\n", sessions)
+}
+
+func sortedKeys(m map[string]*stats) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// label returns the destination label of the metrics. Temporary queues
+// are tracked using a single label, since each session creates queues
+// with unique names that would otherwise accumulate without bound.
+func label(dest string) string {
+	if strings.HasPrefix(dest, string(routeTemp)) {
+		return string(routeTemp)
+	}
+	return dest
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes the label value for the prometheus text format.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/drone/mq/stomp"
+)
+
+func TestMetrics(t *testing.T) {
+	client, server := stomp.Pipe()
+
+	sub := stomp.NewMessage()
+	sub.ID = []byte("1")
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClient
+	sess := requestSession()
+	sess.peer = server
+
+	router := newRouter()
+	router.sessions[sess] = struct{}{}
+	router.subscribe(sess, sub)
+
+	for i := 0; i < 2; i++ {
+		msg := stomp.NewMessage()
+		msg.Method = stomp.MethodSend
+		msg.Dest = []byte("/queue/test")
+		router.send(sess, msg)
+	}
+
+	got := <-client.Receive()
+	ack := stomp.NewMessage()
+	ack.ID = got.Ack
+	router.ack(sess, ack)
+
+	var buf bytes.Buffer
+	router.writeMetrics(&buf)
+	out := buf.String()
+
+	var tests = []string{
+		`mq_messages_published_total{destination="/queue/test"} 2`,
+		`mq_messages_delivered_total{destination="/queue/test"} 2`,
+		`mq_messages_acked_total{destination="/queue/test"} 1`,
+		`mq_messages_nacked_total{destination="/queue/test"} 0`,
+		`mq_messages_pending{destination="/queue/test"} 0`,
+		`mq_messages_inflight{destination="/queue/test"} 1`,
+		`mq_subscribers{destination="/queue/test"} 1`,
+		`mq_sessions 1`,
+		`# TYPE mq_messages_published_total counter`,
+		`# TYPE mq_messages_pending gauge`,
+	}
+	for _, test := range tests {
+		if !strings.Contains(out, test+"\n") {
+			t.Errorf("Want metrics to include %q", test)
+		}
+	}
+}
+
+func TestMetricsTempQueue(t *testing.T) {
+	router := newRouter()
+
+	// each session subscribes to a temporary queue
+	// with a unique name.
+	for _, dest := range []string{"/temp-queue/1", "/temp-queue/2"} {
+		_, server := stomp.Pipe()
+		sess := requestSession()
+		sess.peer = server
+		router.sessions[sess] = struct{}{}
+
+		sub := stomp.NewMessage()
+		sub.ID = []byte("1")
+		sub.Dest = []byte(dest)
+		router.subscribe(sess, sub)
+
+		msg := stomp.NewMessage()
+		msg.Method = stomp.MethodSend
+		msg.Dest = []byte(dest)
+		router.send(sess, msg)
+	}
+
+	var buf bytes.Buffer
+	router.writeMetrics(&buf)
+	out := buf.String()
+
+	var tests = []string{
+		`mq_messages_published_total{destination="/temp-queue/"} 2`,
+		`mq_messages_delivered_total{destination="/temp-queue/"} 2`,
+		`mq_subscribers{destination="/temp-queue/"} 2`,
+	}
+	for _, test := range tests {
+		if !strings.Contains(out, test+"\n") {
+			t.Errorf("Want metrics to include %q", test)
+		}
+	}
+	if len(router.metrics.dests) != 1 {
+		t.Errorf("Want temporary queues tracked using a single destination, got %d", len(router.metrics.dests))
+	}
+}
+
+func TestMetricsRedelivery(t *testing.T) {
+	client, server := stomp.Pipe()
+
+	sub := stomp.NewMessage()
+	sub.ID = []byte("1")
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClientIndividual
+	sess := requestSession()
+	sess.peer = server
+
+	router := newRouter()
+	router.sessions[sess] = struct{}{}
+	router.subscribe(sess, sub)
+
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/queue/test")
+	router.send(sess, msg)
+
+	// the redelivered message is not counted as published.
+	got := <-client.Receive()
+	nack := stomp.NewMessage()
+	nack.ID = got.Ack
+	router.nack(sess, nack)
+	<-client.Receive()
+
+	stats := router.metrics.dest("/queue/test")
+	if n := stats.get(statPublished); n != 1 {
+		t.Errorf("Want 1 published message, got %d", n)
+	}
+	if n := stats.get(statDelivered); n != 2 {
+		t.Errorf("Want 2 delivered messages, got %d", n)
+	}
+}
+
+func Test_escapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("Want label value escaped, got %q", got)
+	}
+}
@@ -1,5 +1,11 @@
 package server
 
+import (
+	"time"
+
+	"github.com/drone/mq/stomp"
+)
+
 // Option configures server options.
 type Option func(*Server)
 
@@ -16,3 +22,149 @@ func WithAuth(auth Authorizer) Option {
 func WithCredentials(username, password string) Option {
 	return WithAuth(BasicAuth(username, password))
 }
+
+// WithDispatch returns an Option which configures how queue messages
+// are distributed to subscribers. The default strategy is round-robin.
+func WithDispatch(strategy Dispatch) Option {
+	return func(s *Server) {
+		s.router.dispatch = strategy
+	}
+}
+
+// WithHeartbeat returns an Option which configures the heart-beat
+// intervals sent to the client when the session is established. The
+// send interval is the smallest interval at which the server sends
+// heart-beats, and the recv interval is the desired interval at which
+// the server receives heart-beats. A zero interval disables heart-beats
+// in that direction.
+func WithHeartbeat(send, recv time.Duration) Option {
+	return func(s *Server) {
+		s.router.heartbeat = stomp.FormatHeartbeat(send, recv)
+	}
+}
+
+// WithMaxHeartbeat returns an Option which configures the maximum
+// heart-beat interval negotiated with the client. A client that sends
+// heart-beats less often than the maximum is rejected, and a client that
+// requests heart-beats less often receives them at the maximum interval.
+// The default maximum is 5 minutes. A value of zero removes the limit.
+func WithMaxHeartbeat(max time.Duration) Option {
+	return func(s *Server) {
+		s.router.heartbeatMax = max
+	}
+}
+
+// WithMaxFrameSize returns an Option which limits the size in bytes of
+// frames received from the client. The default limit is 1MB. A value of
+// zero removes the limit, however frames are always limited to 64MB.
+func WithMaxFrameSize(size int) Option {
+	return func(s *Server) {
+		s.frameSize = size
+	}
+}
+
+// WithMaxHeaders returns an Option which limits the number of headers in
+// frames received from the client. The default limit is 1000. A value of
+// zero removes the limit.
+func WithMaxHeaders(count int) Option {
+	return func(s *Server) {
+		s.headers = count
+	}
+}
+
+// WithMaxHeaderSize returns an Option which limits the length in bytes of
+// each header in frames received from the client. The default limit is
+// 10KB. A value of zero removes the limit.
+func WithMaxHeaderSize(size int) Option {
+	return func(s *Server) {
+		s.headerSize = size
+	}
+}
+
+// WithQueueLimit returns an Option which configures the default maximum
+// depth of queues and the policy applied when a queue is full.
+func WithQueueLimit(limit QueueLimit) Option {
+	return func(s *Server) {
+		s.router.defaultLimit = limit
+	}
+}
+
+// WithQueueLimitFor returns an Option which configures the maximum depth
+// of queues matching the destination pattern, where the wildcard character
+// (*) matches any sequence of characters. If multiple patterns match a
+// queue, the first configured pattern is used.
+func WithQueueLimitFor(pattern string, limit QueueLimit) Option {
+	return func(s *Server) {
+		s.router.limits = append(s.router.limits, queueLimit{
+			pattern: pattern,
+			limit:   limit,
+		})
+	}
+}
+
+// WithDurableLimit returns an Option which limits the number of messages
+// buffered for each offline durable subscription. If the limit is exceeded
+// the oldest message is discarded. The default limit is 1000. A value of
+// zero indicates the limit is unlimited.
+func WithDurableLimit(limit int) Option {
+	return func(s *Server) {
+		s.router.durableLimit = limit
+	}
+}
+
+// WithRetention returns an Option which bounds the messages retained by
+// each topic to the given count and age. The default count is 1000. A
+// value of zero indicates the count or age is unlimited.
+func WithRetention(count int, age time.Duration) Option {
+	return func(s *Server) {
+		s.router.retainCount = count
+		s.router.retainAge = age
+	}
+}
+
+// WithSendBuffer returns an Option which configures the number of
+// outbound messages buffered for each client session. A value of zero
+// disables buffering and messages are written directly to the client.
+func WithSendBuffer(size int) Option {
+	return func(s *Server) {
+		s.sendBuffer = size
+	}
+}
+
+// WithSlowConsumer returns an Option which configures how messages are
+// handled when the outbound buffer of a client session is full. The
+// default policy blocks the sender until the buffer has capacity. The
+// policy only discards topic messages; queue messages always wait.
+func WithSlowConsumer(policy SlowConsumer) Option {
+	return func(s *Server) {
+		s.slowConsumer = policy
+	}
+}
+
+// WithMaxRedeliveries returns an Option which configures the maximum
+// number of times a message is redelivered before it is moved to the
+// dead-letter queue. The default value of zero allows unlimited redelivery.
+func WithMaxRedeliveries(max int) Option {
+	return func(s *Server) {
+		s.router.redeliveries = max
+	}
+}
+
+// WithPolicy returns an Option which configures a policy used to
+// authorize access to individual destinations.
+func WithPolicy(policy Policy) Option {
+	return func(s *Server) {
+		s.router.policy = policy
+	}
+}
+
+// WithStorage returns an Option which configures a persistent datastore
+// at the given path. Messages sent with persist:true are written to the
+// datastore and restored to the appropriate queues when the server starts.
+// The messages are restored after all options are applied, and NewServer
+// returns an error if the datastore cannot be loaded.
+func WithStorage(path string) Option {
+	return func(s *Server) {
+		s.storage = path
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Policy is used to authorize a session to publish and subscribe to
+// individual destinations. If the method returns a non-nil error an
+// error message is sent to the peer and the message is discarded.
+type Policy interface {
+	// Read authorizes the user to subscribe to the destination.
+	Read(user, dest string) error
+
+	// Write authorizes the user to publish to the destination.
+	Write(user, dest string) error
+}
+
+// access list permissions.
+const (
+	permRead  = 1 << iota // subscribe to the destination
+	permWrite             // publish to the destination
+)
+
+// rule is an access list entry that grants a user permission to
+// destinations matching the pattern.
+type rule struct {
+	user    string
+	pattern string
+	perm    int
+}
+
+// accessList is a Policy that grants access to destinations based
+// on a list of rules. Access is denied unless a matching rule grants
+// the requested permission.
+type accessList struct {
+	rules []rule
+}
+
+// Read authorizes the user to subscribe to the destination.
+func (a *accessList) Read(user, dest string) error {
+	return a.check(user, dest, permRead)
+}
+
+// Write authorizes the user to publish to the destination.
+func (a *accessList) Write(user, dest string) error {
+	return a.check(user, dest, permWrite)
+}
+
+func (a *accessList) check(user, dest string, perm int) error {
+	for _, r := range a.rules {
+		if r.perm&perm == 0 {
+			continue
+		}
+		if r.user != "*" && r.user != user {
+			continue
+		}
+		if match(r.pattern, dest) {
+			return nil
+		}
+	}
+	return ErrNotAuthorized
+}
+
+// LoadPolicy reads an access list policy from the named file.
+func LoadPolicy(path string) (Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePolicy(f)
+}
+
+// ParsePolicy parses an access list policy. Each line of the policy
+// grants a user permission to read (r), write (w) or read and write (rw)
+// destinations matching the pattern. The wildcard character (*) matches
+// any user, or any sequence of characters in the destination.
+//
+//	# user   perm   destination
+//	janedoe  rw     /queue/builds.*
+//	*        r      /topic/*
+func ParsePolicy(r io.Reader) (Policy, error) {
+	var (
+		list = new(accessList)
+		scan = bufio.NewScanner(r)
+		line int
+	)
+	for scan.Scan() {
+		line++
+		text := strings.TrimSpace(scan.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("stomp: policy line %d: want user, permission and destination", line)
+		}
+		rule := rule{
+			user:    fields[0],
+			pattern: fields[2],
+		}
+		for _, c := range fields[1] {
+			switch c {
+			case 'r':
+				rule.perm |= permRead
+			case 'w':
+				rule.perm |= permWrite
+			default:
+				return nil, fmt.Errorf("stomp: policy line %d: invalid permission %q", line, fields[1])
+			}
+		}
+		list.rules = append(list.rules, rule)
+	}
+	return list, scan.Err()
+}
+
+// match returns true if the destination matches the pattern, where
+// the wildcard character (*) matches any sequence of characters.
+func match(pattern, dest string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == dest
+	}
+	if !strings.HasPrefix(dest, parts[0]) {
+		return false
+	}
+	dest = dest[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(dest, part)
+		if i == -1 {
+			return false
+		}
+		dest = dest[i+len(part):]
+	}
+	return strings.HasSuffix(dest, parts[len(parts)-1])
+}
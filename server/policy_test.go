@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone/mq/stomp"
+)
+
+var testPolicy = `
+# user   perm   destination
+janedoe  rw     /queue/builds.*
+johndoe  w      /queue/builds.*
+*        r      /topic/*
+`
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		user  string
+		dest  string
+		read  bool
+		write bool
+	}{
+		{"janedoe", "/queue/builds.linux", true, true},
+		{"johndoe", "/queue/builds.linux", false, true},
+		{"johndoe", "/queue/deploys", false, false},
+		{"", "/topic/events", true, false},
+		{"janedoe", "/topic/events", true, false},
+	}
+	for _, test := range tests {
+		if got := policy.Read(test.user, test.dest) == nil; got != test.read {
+			t.Errorf("Want read %s %s %v, got %v", test.user, test.dest, test.read, got)
+		}
+		if got := policy.Write(test.user, test.dest) == nil; got != test.write {
+			t.Errorf("Want write %s %s %v, got %v", test.user, test.dest, test.write, got)
+		}
+	}
+}
+
+func TestParsePolicyMalformed(t *testing.T) {
+	var tests = []string{
+		"janedoe rw",               // missing destination
+		"janedoe rx /queue/builds", // invalid permission
+	}
+	for _, test := range tests {
+		if _, err := ParsePolicy(strings.NewReader(test)); err == nil {
+			t.Errorf("Want error parsing policy %q", test)
+		}
+	}
+}
+
+func TestPolicySubscribe(t *testing.T) {
+	policy, _ := ParsePolicy(strings.NewReader(testPolicy))
+
+	_, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+	sess.msg = stomp.NewMessage()
+	sess.msg.User = []byte("johndoe")
+
+	router := newRouter()
+	router.policy = policy
+
+	sub := stomp.NewMessage()
+	sub.ID = []byte("1")
+	sub.Dest = []byte("/queue/builds.linux")
+	if err := router.subscribe(sess, sub); err != ErrNotAuthorized {
+		t.Errorf("Want subscribe denied by policy, got %v", err)
+	}
+	if len(router.destinations) != 0 {
+		t.Errorf("Want destination not created when subscribe denied")
+	}
+
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/queue/builds.linux")
+	if err := router.send(sess, msg); err != nil {
+		t.Errorf("Want publish permitted by policy, got %v", err)
+	}
+}
+
+func Test_match(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		dest    string
+		match   bool
+	}{
+		{"/queue/builds", "/queue/builds", true},
+		{"/queue/builds", "/queue/builds.linux", false},
+		{"/queue/*", "/queue/builds", true},
+		{"/queue/*.linux", "/queue/builds.linux", true},
+		{"/queue/*.linux", "/queue/builds.windows", false},
+		{"*", "/topic/events", true},
+		{"/*/builds.*", "/queue/builds.linux", true},
+		{"/topic/*", "/queue/builds", false},
+	}
+	for _, test := range tests {
+		if got := match(test.pattern, test.dest); got != test.match {
+			t.Errorf("Want match %q %q %v, got %v", test.pattern, test.dest, test.match, got)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package server
 
 import (
+	"bytes"
+	"container/heap"
 	"container/list"
-	"math/rand"
 	"sync"
 	"time"
 
@@ -13,38 +14,206 @@ type queue struct {
 	sync.RWMutex
 
 	dest []byte
-	subs map[*subscription]struct{}
-	list *list.List
+	subs []*subscription
+
+	// lists holds the pending messages, with one list
+	// per priority level, in the order they are delivered.
+	lists [maxPriority + 1]list.List
+
+	// dispatcher selects the subscriber
+	// that receives the next message.
+	dispatcher *dispatcher
+
+	// exclusive is the active exclusive subscriber, if
+	// any, and receives all messages sent to the queue.
+	exclusive *subscription
+
+	// groups maps message groups to the
+	// subscriber that receives the group.
+	groups map[string]*subscription
+
+	// sched holds messages scheduled for future delivery,
+	// and timer fires when the earliest message is due.
+	sched schedule
+	timer *time.Timer
+
+	storage store
+	stats   *stats
+
+	// expire is invoked when an unacknowledged
+	// message exceeds the subscription ack timeout.
+	expire func(*session, string)
+
+	// limit is the maximum queue depth, and bytes is
+	// the size of the pending message bodies.
+	limit QueueLimit
+	bytes int
+
+	// arrival records the order in which the pending and
+	// scheduled messages were published, so that the oldest
+	// message can be dropped when the queue is full.
+	arrival map[*stomp.Message]uint64
+	seq     uint64
+
+	// owner is the session that owns the queue, if the
+	// queue is temporary. It is guarded by the router lock.
+	owner *session
 }
 
 func newQueue(dest []byte) *queue {
 	return &queue{
-		dest: dest,
-		subs: make(map[*subscription]struct{}),
-		list: list.New(),
+		dest:       dest,
+		dispatcher: newDispatcher(DispatchRoundRobin),
+		groups:     make(map[string]*subscription),
+		arrival:    make(map[*stomp.Message]uint64),
 	}
 }
 
 func (q *queue) publish(m *stomp.Message) error {
 	c := m.Copy()
-	c.ID = stomp.Rand()
+	if len(c.ID) == 0 {
+		c.ID = stomp.Rand()
+	}
 	c.Method = stomp.MethodMessage
+
 	q.Lock()
-	q.list.PushBack(c)
+	// the queue limit only applies to messages sent by a producer,
+	// and not to messages that are redelivered or restored.
+	if bytes.Equal(m.Method, stomp.MethodSend) && !q.reserve(c) {
+		q.Unlock()
+		c.Release()
+		return errQueueFull
+	}
+	q.bytes += len(c.Body)
+	q.seq++
+	q.arrival[c] = q.seq
+
+	// if the message is scheduled for future delivery it is
+	// held aside until it is due.
+	if due := deliverAt(c); due.After(time.Now()) {
+		q.schedule(c, due)
+		q.Unlock()
+		return nil
+	}
+
+	q.pushBack(c)
 	q.Unlock()
 	return q.process()
 }
 
+// reserve returns true if the queue has capacity for the message. If
+// the overflow policy drops the oldest messages, messages are removed
+// in the order they were published to make room. The caller must hold
+// the lock.
+func (q *queue) reserve(m *stomp.Message) bool {
+	for q.full(m) {
+		if q.limit.Overflow != OverflowDropOldest || !q.dropOldest() {
+			return false
+		}
+	}
+	return true
+}
+
+// dropOldest discards the pending or scheduled message that was
+// published first, and returns false if the queue is empty. Restored
+// messages have no arrival order and are dropped first. The caller
+// must hold the lock.
+func (q *queue) dropOldest() bool {
+	var (
+		elem  *list.Element
+		index = -1
+		first uint64
+	)
+	for p := range q.lists {
+		for e := q.lists[p].Front(); e != nil; e = e.Next() {
+			seq := q.arrival[e.Value.(*stomp.Message)]
+			if elem == nil || seq < first {
+				elem, first = e, seq
+			}
+		}
+	}
+	for i, s := range q.sched {
+		seq := q.arrival[s.msg]
+		if (elem == nil && index == -1) || seq < first {
+			elem, index, first = nil, i, seq
+		}
+	}
+
+	var old *stomp.Message
+	switch {
+	case index != -1:
+		// the timer is not reset, since dispatching
+		// when no message is due has no effect.
+		old = heap.Remove(&q.sched, index).(*scheduled).msg
+	case elem != nil:
+		old = elem.Value.(*stomp.Message)
+		q.lists[priority(old)].Remove(elem)
+	default:
+		return false
+	}
+	delete(q.arrival, old)
+	q.bytes -= len(old.Body)
+	q.forget(old)
+	q.stats.incr(statDropped)
+	old.Release()
+	return true
+}
+
+// full returns true if adding the message would exceed
+// the queue limit. The caller must hold the lock.
+func (q *queue) full(m *stomp.Message) bool {
+	if q.limit.MaxLength != 0 && q.len()+len(q.sched) >= q.limit.MaxLength {
+		return true
+	}
+	return q.limit.MaxBytes != 0 && q.bytes+len(m.Body) > q.limit.MaxBytes
+}
+
+// schedule adds the message to the schedule and resets the timer
+// if the message is the next message due. The caller must hold the lock.
+func (q *queue) schedule(m *stomp.Message, due time.Time) {
+	heap.Push(&q.sched, &scheduled{msg: m, due: due})
+	if q.sched[0].msg != m {
+		return
+	}
+	if q.timer == nil {
+		q.timer = time.AfterFunc(due.Sub(time.Now()), q.dispatch)
+	} else {
+		q.timer.Reset(due.Sub(time.Now()))
+	}
+}
+
+// dispatch moves scheduled messages that are due to the queue, delivers
+// them to the eligible subscribers, and resets the timer for the next
+// scheduled message.
+func (q *queue) dispatch() {
+	now := time.Now()
+
+	q.Lock()
+	for len(q.sched) != 0 && !q.sched[0].due.After(now) {
+		s := heap.Pop(&q.sched).(*scheduled)
+		q.pushBack(s.msg)
+	}
+	if len(q.sched) != 0 {
+		q.timer.Reset(q.sched[0].due.Sub(now))
+	}
+	q.Unlock()
+
+	q.process()
+}
+
 func (q *queue) subscribe(s *subscription, m *stomp.Message) error {
 	q.Lock()
-	q.subs[s] = struct{}{}
+	q.subs = append(q.subs, s)
+	if s.exclusive && q.exclusive == nil {
+		q.exclusive = s
+	}
 	q.Unlock()
 	return q.process()
 }
 
 func (q *queue) unsubscribe(s *subscription, m *stomp.Message) error {
 	q.Lock()
-	delete(q.subs, s)
+	q.remove(s)
 	q.Unlock()
 	return nil
 }
@@ -52,17 +221,71 @@ func (q *queue) unsubscribe(s *subscription, m *stomp.Message) error {
 func (q *queue) disconnect(s *session) error {
 	q.Lock()
 	for _, subscription := range s.sub {
-		delete(q.subs, subscription)
+		q.remove(subscription)
 	}
 	q.Unlock()
 	return nil
 }
 
+// remove removes the subscription from the subscriber list and
+// releases its message groups. If the subscription is the active
+// exclusive subscriber the next exclusive subscriber takes over.
+// The caller must hold the lock.
+func (q *queue) remove(s *subscription) {
+	for i, sub := range q.subs {
+		if sub == s {
+			q.subs = append(q.subs[:i], q.subs[i+1:]...)
+			break
+		}
+	}
+	for group, sub := range q.groups {
+		if sub == s {
+			delete(q.groups, group)
+		}
+	}
+	if q.exclusive == s {
+		q.exclusive = nil
+		for _, sub := range q.subs {
+			if sub.exclusive {
+				q.exclusive = sub
+				break
+			}
+		}
+	}
+}
+
+// next returns the subscriber that receives the message, or nil
+// if no subscriber is eligible. The caller must hold the lock.
+func (q *queue) next(m *stomp.Message) *subscription {
+	if q.exclusive != nil {
+		if accepts(q.exclusive, m) {
+			return q.exclusive
+		}
+		return nil
+	}
+
+	group := m.Header.Get(stomp.HeaderGroup)
+	if len(group) != 0 {
+		if sub, ok := q.groups[string(group)]; ok {
+			if accepts(sub, m) {
+				return sub
+			}
+			return nil
+		}
+	}
+
+	sub := q.dispatcher.next(q.subs, m)
+	if sub != nil && len(group) != 0 {
+		q.groups[string(group)] = sub
+	}
+	return sub
+}
+
 // returns true if the topic has zero subscribers indicating
 // that it can be recycled.
 func (q *queue) recycle() (ok bool) {
 	q.RLock()
-	ok = len(q.subs) == 0 && q.list.Len() == 0
+	ok = len(q.subs) == 0 && q.len() == 0 && len(q.sched) == 0
 	q.RUnlock()
 	return
 }
@@ -72,73 +295,162 @@ func (q *queue) destination() string {
 	return string(q.dest)
 }
 
+// returns the number of messages waiting to be delivered.
+func (q *queue) pending() (n int) {
+	q.RLock()
+	n = q.len() + len(q.sched)
+	q.RUnlock()
+	return
+}
+
+// len returns the number of pending messages, excluding
+// scheduled messages. The caller must hold the lock.
+func (q *queue) len() (n int) {
+	for p := range q.lists {
+		n += q.lists[p].Len()
+	}
+	return
+}
+
+// returns the number of subscribers.
+func (q *queue) subscribers() (n int) {
+	q.RLock()
+	n = len(q.subs)
+	q.RUnlock()
+	return
+}
+
 func (q *queue) restore(m *stomp.Message) error {
 	q.Lock()
-	q.list.PushFront(m)
+	q.bytes += len(m.Body)
+	q.pushFront(m)
 	q.Unlock()
 	return q.process()
 }
 
+// pushBack inserts the message after all messages of the same or
+// higher priority. The caller must hold the lock.
+func (q *queue) pushBack(m *stomp.Message) {
+	q.lists[priority(m)].PushBack(m)
+}
+
+// pushFront inserts the message before all messages of the same or
+// lower priority. The caller must hold the lock.
+func (q *queue) pushFront(m *stomp.Message) {
+	q.lists[priority(m)].PushFront(m)
+}
+
+// default and maximum message priority.
+const (
+	defaultPriority = 4
+	maxPriority     = 9
+)
+
+// priority returns the message priority in the range 0 to 9.
+func priority(m *stomp.Message) int {
+	v := m.Header.Get(stomp.HeaderPriority)
+	if len(v) == 0 {
+		return defaultPriority
+	}
+	p := stomp.ParseInt(v)
+	if p > maxPriority {
+		return maxPriority
+	}
+	return p
+}
+
+// process delivers the pending messages to the eligible subscribers,
+// until no remaining message can be delivered.
 func (q *queue) process() error {
 	q.Lock()
 	defer q.Unlock()
 
+	for p := maxPriority; p >= 0; p-- {
+		q.processList(&q.lists[p])
+	}
+	return nil
+}
+
+// processList delivers the messages in the list to the eligible
+// subscribers. The caller must hold the lock.
+func (q *queue) processList(l *list.List) {
 	var next *list.Element
-	for e := q.list.Front(); e != nil; e = next {
+	for e := l.Front(); e != nil; e = next {
 		next = e.Next()
 		m := e.Value.(*stomp.Message)
 
 		// if the message expires we can remove it from the list
 		if len(m.Expires) != 0 && stomp.ParseInt64(m.Expires) < time.Now().Unix() {
-			q.list.Remove(e)
+			l.Remove(e)
+			delete(q.arrival, m)
+			q.bytes -= len(m.Body)
+			q.forget(m)
+			q.stats.incr(statExpired)
 			continue
 		}
 
-		for _, sub := range shuffle(q.subs) {
-			// evaluate against the sql selector
-			if sub.selector != nil {
-				if ok, _ := sub.selector.Eval(m.Header); !ok {
-					continue
-				}
-			}
-
-			if sub.prefetch != 0 && sub.prefetch == sub.Pending() {
-				continue
-			}
-			// increment the pending prefectch
-			if sub.prefetch != 0 {
-				sub.PendingIncr()
+		sub := q.next(m)
+		if sub == nil {
+			continue
+		}
+		if sub.ack {
+			// increment the count of messages pending
+			// acknowledgement, used by the prefetch limit
+			// and the least-pending dispatch strategy.
+			sub.PendingIncr()
+			m.Subs = sub.id
+			m.Ack = stomp.Rand()
+			sess := sub.session
+			sess.Lock()
+			sess.ack[string(m.Ack)] = m.Copy()
+			if sub.cumulative {
+				sub.acks = append(sub.acks, string(m.Ack))
 			}
-			if sub.ack {
-				m.Subs = sub.id
-				m.Ack = stomp.Rand()
-				sub.session.Lock()
-				sub.session.ack[string(m.Ack)] = m.Copy()
-				sub.session.Unlock()
+			if sub.timeout != 0 && q.expire != nil {
+				sess.lease(string(m.Ack), sub.timeout, q.expire)
 			}
-
-			m.Subs = sub.id
-			sub.session.send(m)
-			q.list.Remove(e)
-			return nil
+			sess.Unlock()
+		} else {
+			// the message does not require acknowledgement
+			// and can be removed from storage.
+			q.forget(m)
 		}
+
+		m.Subs = sub.id
+		q.stats.incr(statDelivered)
+		l.Remove(e)
+		delete(q.arrival, m)
+		q.bytes -= len(m.Body)
+		sub.session.send(m)
 	}
-	return nil
 }
 
-// helper function to randomize the list of subscribers in an attempt
-// to more evenly distribute messages in a round robin fashion.
-//
-// NOTE this is a basic implementation and we recognize there is plenty
-// of room for improvement here.
-func shuffle(subm map[*subscription]struct{}) []*subscription {
-	var subs []*subscription
-	for sub := range subm {
-		subs = append(subs, sub)
+// purge discards the pending and scheduled messages.
+func (q *queue) purge() {
+	q.Lock()
+	for p := range q.lists {
+		for e := q.lists[p].Front(); e != nil; e = e.Next() {
+			e.Value.(*stomp.Message).Release()
+		}
+		q.lists[p].Init()
+	}
+	for _, s := range q.sched {
+		s.msg.Release()
+	}
+	q.sched = q.sched[:0]
+	for m := range q.arrival {
+		delete(q.arrival, m)
+	}
+	if q.timer != nil {
+		q.timer.Stop()
 	}
-	for i := range subs {
-		j := rand.Intn(i + 1)
-		subs[i], subs[j] = subs[j], subs[i]
+	q.bytes = 0
+	q.Unlock()
+}
+
+// forget removes the persisted message from storage.
+func (q *queue) forget(m *stomp.Message) {
+	if q.storage != nil && shouldPersist(m) {
+		q.storage.delete(m)
 	}
-	return subs
 }
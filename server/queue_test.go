@@ -1,3 +1,300 @@
 package server
 
-// TODO
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/mq/stomp"
+)
+
+func Test_queue_publish_delay(t *testing.T) {
+	peer, client := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = peer
+	defer sess.release()
+
+	sub := stomp.NewMessage()
+	sub.Dest = []byte("/queue/test")
+
+	q := newQueue(sub.Dest)
+	q.subscribe(sess.subs(sub), sub)
+
+	at := time.Now().Add(50 * time.Millisecond)
+	m := stomp.NewMessage()
+	m.Dest = []byte("/queue/test")
+	m.Body = []byte("hello")
+	m.Header.Add(stomp.HeaderDeliverAt,
+		strconv.AppendInt(nil, at.UnixNano()/int64(time.Millisecond), 10),
+	)
+	q.publish(m)
+
+	select {
+	case <-client.Receive():
+		t.Errorf("expect scheduled message is not delivered before it is due")
+	default:
+	}
+	if q.recycle() {
+		t.Errorf("expect queue with scheduled messages is not recycled")
+	}
+
+	select {
+	case got := <-client.Receive():
+		if !bytes.Equal(got.Body, m.Body) {
+			t.Errorf("expect scheduled message delivered")
+		}
+		// the deliver-at header has millisecond precision.
+		if time.Now().Before(at.Truncate(time.Millisecond)) {
+			t.Errorf("expect scheduled message delivered when due")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expect scheduled message delivered when due")
+	}
+}
+
+func Test_queue_publish_delay_batch(t *testing.T) {
+	peer, client := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = peer
+	defer sess.release()
+
+	sub := stomp.NewMessage()
+	sub.Dest = []byte("/queue/test")
+
+	q := newQueue(sub.Dest)
+	q.subscribe(sess.subs(sub), sub)
+
+	// messages due at the same instant are all delivered
+	// when the timer fires.
+	at := time.Now().Add(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		m := stomp.NewMessage()
+		m.Dest = []byte("/queue/test")
+		m.Header.Add(stomp.HeaderDeliverAt,
+			strconv.AppendInt(nil, at.UnixNano()/int64(time.Millisecond), 10),
+		)
+		q.publish(m)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-client.Receive():
+		case <-time.After(time.Second):
+			t.Fatalf("expect scheduled message %d delivered when due", i+1)
+		}
+	}
+	if q.pending() != 0 {
+		t.Errorf("expect no pending messages after scheduled delivery")
+	}
+}
+
+func Test_schedule_order(t *testing.T) {
+	var (
+		now  = time.Now()
+		msg1 = stomp.NewMessage()
+		msg2 = stomp.NewMessage()
+	)
+	q := newQueue([]byte("/queue/test"))
+	q.Lock()
+	q.schedule(msg2, now.Add(time.Hour*2))
+	q.schedule(msg1, now.Add(time.Hour))
+	q.Unlock()
+	q.timer.Stop()
+
+	if q.sched[0].msg != msg1 {
+		t.Errorf("expect earliest scheduled message at the head of the schedule")
+	}
+}
+
+func Test_normalizeDelay(t *testing.T) {
+	m := stomp.NewMessage()
+	m.Header.Add(stomp.HeaderDelay, []byte("60000"))
+	normalizeDelay(m)
+
+	if len(m.Header.Get(stomp.HeaderDelay)) != 0 {
+		t.Errorf("expect delay header removed")
+	}
+	due := deliverAt(m)
+	if due.Before(time.Now().Add(59*time.Second)) || due.After(time.Now().Add(61*time.Second)) {
+		t.Errorf("expect deliver-at header set relative to the current time, got %s", due)
+	}
+}
+
+func Test_queue_priority(t *testing.T) {
+	q := newQueue([]byte("/queue/test"))
+
+	for _, body := range []string{"4a", "9a", "0a", "4b", "9b", "-a"} {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodSend
+		m.Body = []byte(body)
+		if body[0] != '-' {
+			m.Header.Add(stomp.HeaderPriority, []byte(body[:1]))
+		}
+		q.publish(m)
+	}
+
+	// a restored message is placed ahead of
+	// messages with the same priority.
+	m := stomp.NewMessage()
+	m.Body = []byte("4r")
+	m.Header.Add(stomp.HeaderPriority, []byte("4"))
+	q.restore(m)
+
+	var got []string
+	for _, m := range pendingMessages(q) {
+		got = append(got, string(m.Body))
+	}
+	want := []string{"9a", "9b", "4r", "4a", "4b", "-a", "0a"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Want messages ordered by priority %v, got %v", want, got)
+	}
+}
+
+// pendingMessages returns the pending messages in delivery order.
+func pendingMessages(q *queue) (msgs []*stomp.Message) {
+	for p := maxPriority; p >= 0; p-- {
+		for e := q.lists[p].Front(); e != nil; e = e.Next() {
+			msgs = append(msgs, e.Value.(*stomp.Message))
+		}
+	}
+	return
+}
+
+func Test_queue_leastPending(t *testing.T) {
+	q := newQueue([]byte("/queue/test"))
+	q.dispatcher = newDispatcher(DispatchLeastPending)
+
+	// the subscriptions require acknowledgement without a
+	// prefetch limit.
+	var subs []*subscription
+	var clients []stomp.Peer
+	subscribe := func() {
+		peer, client := stomp.Pipe()
+		sess := requestSession()
+		sess.peer = peer
+		m := stomp.NewMessage()
+		m.Dest = []byte("/queue/test")
+		m.Ack = stomp.AckClientIndividual
+		sub := sess.subs(m)
+		q.subscribe(sub, m)
+		subs = append(subs, sub)
+		clients = append(clients, client)
+	}
+	publish := func() {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodSend
+		m.Dest = []byte("/queue/test")
+		q.publish(m)
+	}
+
+	subscribe()
+	publish()
+	publish()
+	subscribe()
+	publish()
+	publish()
+
+	for i, want := range []int{2, 2} {
+		if got := subs[i].Pending(); got != want {
+			t.Errorf("Want %d messages pending acknowledgement, got %d", want, got)
+		}
+		for j := 0; j < want; j++ {
+			select {
+			case <-clients[i].Receive():
+			case <-time.After(time.Second):
+				t.Errorf("Want message delivered to subscriber")
+			}
+		}
+	}
+}
+
+func Test_queue_exclusive(t *testing.T) {
+	q := newQueue([]byte("/queue/test"))
+
+	var (
+		clients []stomp.Peer
+		subs    []*subscription
+	)
+	for i, exclusive := range []bool{false, true, true} {
+		peer, client := stomp.Pipe()
+		sess := requestSession()
+		sess.peer = peer
+		defer sess.release()
+
+		m := stomp.NewMessage()
+		m.ID = []byte(strconv.Itoa(i))
+		m.Dest = q.dest
+		if exclusive {
+			m.Header.Add(stomp.HeaderExclusive, []byte("true"))
+		}
+		sub := sess.subs(m)
+		q.subscribe(sub, m)
+		clients = append(clients, client)
+		subs = append(subs, sub)
+	}
+
+	for i := 0; i < 3; i++ {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodSend
+		q.publish(m)
+	}
+	if got := len(clients[1].Receive()); got != 3 {
+		t.Errorf("Want exclusive subscriber receives all messages, got %d", got)
+	}
+
+	// the next exclusive subscriber takes over when
+	// the active exclusive subscriber unsubscribes.
+	q.unsubscribe(subs[1], nil)
+	q.publish(stomp.NewMessage())
+	if got := len(clients[2].Receive()); got != 1 {
+		t.Errorf("Want next exclusive subscriber receives messages, got %d", got)
+	}
+	if got := len(clients[0].Receive()); got != 0 {
+		t.Errorf("Want non-exclusive subscriber receives no messages, got %d", got)
+	}
+}
+
+func Test_queue_groups(t *testing.T) {
+	q := newQueue([]byte("/queue/test"))
+
+	var (
+		clients []stomp.Peer
+		subs    []*subscription
+	)
+	for i := 0; i < 2; i++ {
+		peer, client := stomp.Pipe()
+		sess := requestSession()
+		sess.peer = peer
+		defer sess.release()
+
+		m := stomp.NewMessage()
+		m.ID = []byte(strconv.Itoa(i))
+		m.Dest = q.dest
+		sub := sess.subs(m)
+		q.subscribe(sub, m)
+		clients = append(clients, client)
+		subs = append(subs, sub)
+	}
+
+	for i := 0; i < 4; i++ {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodSend
+		m.Header.Add(stomp.HeaderGroup, []byte("octocat/hello-world"))
+		q.publish(m)
+	}
+	if a, b := len(clients[0].Receive()), len(clients[1].Receive()); a != 4 || b != 0 {
+		t.Errorf("Want grouped messages delivered to one subscriber, got %d and %d", a, b)
+	}
+
+	// the group is re-assigned when the subscriber unsubscribes.
+	q.unsubscribe(subs[0], nil)
+	m := stomp.NewMessage()
+	m.Method = stomp.MethodSend
+	m.Header.Add(stomp.HeaderGroup, []byte("octocat/hello-world"))
+	q.publish(m)
+	if got := len(clients[1].Receive()); got != 1 {
+		t.Errorf("Want group re-assigned when the subscriber unsubscribes")
+	}
+}
@@ -3,7 +3,9 @@ package server
 import (
 	"bytes"
 	"errors"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/drone/mq/logger"
 	"github.com/drone/mq/stomp"
@@ -13,11 +15,27 @@ var (
 	errStompMethod    = errors.New("stomp: expected stomp method")
 	errNoSubscription = errors.New("stomp: no such subscription")
 	errNoDestination  = errors.New("stomp: no such destination")
+	errWildcard       = errors.New("stomp: cannot publish to a wildcard destination")
+	errTempQueue      = errors.New("stomp: temporary queue owned by another session")
+	errHeartbeat      = errors.New("stomp: heart-beat interval exceeds the maximum")
 )
 
+const reasonRedeliveries = "max redeliveries exceeded"
+
+// default heart-beat interval.
+var heartbeatTime = time.Second * 30
+
+// default maximum negotiated heart-beat interval.
+var heartbeatMax = time.Minute * 5
+
+// default maximum number of messages retained by a topic.
+const defaultRetainCount = 1000
+
 var (
 	routeTopic = []byte("/topic/")
 	routeQueue = []byte("/queue/")
+	routeDLQ   = []byte("/queue/DLQ.")
+	routeTemp  = []byte("/temp-queue/")
 )
 
 type handler interface {
@@ -29,11 +47,26 @@ type handler interface {
 	disconnect(*session) error
 	process() error
 	recycle() bool
+	pending() int
+	subscribers() int
 }
 
 type router struct {
 	sync.RWMutex
 	authorizer   Authorizer
+	policy       Policy
+	storage      store
+	redeliveries int
+	dispatch     Dispatch
+	defaultLimit QueueLimit
+	retainCount  int
+	retainAge    time.Duration
+	durableLimit int
+	limits       []queueLimit
+	heartbeat    []byte
+	heartbeatMax time.Duration
+	metrics      *metrics
+	wildcards    *trie
 	destinations map[string]handler
 	sessions     map[*session]struct{}
 }
@@ -42,22 +75,54 @@ func newRouter() *router {
 	return &router{
 		destinations: make(map[string]handler),
 		sessions:     make(map[*session]struct{}),
+		heartbeat:    stomp.FormatHeartbeat(heartbeatTime, heartbeatTime),
+		heartbeatMax: heartbeatMax,
+		retainCount:  defaultRetainCount,
+		durableLimit: defaultDurablePending,
+		metrics:      newMetrics(),
+		wildcards:    newTrie(),
 	}
 }
 
 // publish publishes the message to the brokered destination.
 func (r *router) publish(m *stomp.Message) error {
+	if isWildcard(m.Dest) {
+		return errWildcard
+	}
+
 	r.RLock()
 	h, ok := r.destinations[string(m.Dest)]
+	var wildcards []*topic
+	if bytes.HasPrefix(m.Dest, routeTopic) {
+		wildcards = r.wildcards.match(m.Dest)
+	}
 	r.RUnlock()
 
+	// the message is delivered to wildcard subscriptions
+	// matching the topic.
+	for _, t := range wildcards {
+		t.deliver(m)
+	}
+
+	// if the topic does not exist there are no subscribers and the
+	// message can be discarded.
 	if !ok && !shouldCreate(m) {
-		return errNoDestination
+		return nil
 	}
 
-	// if shouldPersist(m) && r.storage != nil {
-	// 	r.storage.put(m)
-	// }
+	// messages sent by a producer are assigned a message id, which
+	// is retained when the message is re-delivered and is used as the
+	// storage key for persistent messages.
+	if bytes.Equal(m.Method, stomp.MethodSend) {
+		m.ID = stomp.Rand()
+		normalizeDelay(m)
+		if shouldPersist(m) && r.storage != nil {
+			if err := r.storage.put(m); err != nil {
+				logger.Warningf("stomp: cannot persist message: %s", err)
+				return err
+			}
+		}
+	}
 
 	if !ok {
 		r.Lock()
@@ -66,21 +131,114 @@ func (r *router) publish(m *stomp.Message) error {
 		// exists now.
 		h, ok = r.destinations[string(m.Dest)]
 		if !ok {
-			h = createHandler(m)
+			h = r.createHandler(m)
 			r.destinations[string(m.Dest)] = h
 		}
 		r.Unlock()
 	}
-	return h.publish(m)
+	err := h.publish(m)
+	if err == errQueueFull {
+		return r.overflow(m)
+	}
+	return err
+}
+
+// published increments the published message counter of the destination.
+// It is only invoked for messages sent by the client, and not for messages
+// that are redelivered or restored. Messages sent to a destination that
+// does not exist are discarded and are not counted.
+func (r *router) published(dest string) {
+	r.RLock()
+	_, ok := r.destinations[dest]
+	r.RUnlock()
+	if ok {
+		r.metrics.dest(dest).incr(statPublished)
+	}
+}
+
+// overflow handles a message sent to a full queue. The message is
+// routed to the dead-letter queue if configured, otherwise rejected.
+func (r *router) overflow(m *stomp.Message) error {
+	// the message is removed from storage. It is persisted
+	// again with a new message id if dead-lettered.
+	if shouldPersist(m) && r.storage != nil {
+		r.storage.delete(m)
+	}
+
+	limit := r.limit(string(m.Dest))
+	if limit.Overflow != OverflowDeadLetter || bytes.HasPrefix(m.Dest, routeDLQ) {
+		logger.Noticef("stomp: reject message sent to %s: %s", m.Dest, errQueueFull)
+		return errQueueFull
+	}
+
+	logger.Noticef("stomp: route message sent to %s to the dead-letter queue", m.Dest)
+	m.Header.Set(stomp.HeaderOrigDest, m.Dest)
+	m.Header.Set(stomp.HeaderReason, []byte(reasonQueueFull))
+	m.Dest = deadLetter(m.Dest)
+	return r.publish(m)
+}
+
+// send publishes the message sent by the session to the brokered
+// destination, if the session is authorized to write to the destination.
+func (r *router) send(sess *session, m *stomp.Message) error {
+	if r.policy != nil {
+		err := r.policy.Write(sess.login(), string(m.Dest))
+		if err != nil {
+			return err
+		}
+	}
+	dest := string(m.Dest)
+	err := r.publish(m)
+	if err == nil {
+		r.published(dest)
+	}
+	return err
 }
 
 // subscribe to the brokered destination.
 func (r *router) subscribe(sess *session, m *stomp.Message) (err error) {
+	if r.policy != nil {
+		err = r.policy.Read(sess.login(), string(m.Dest))
+		if err != nil {
+			return err
+		}
+	}
+
+	// durable subscriptions are identified by the client
+	// id, and are only supported by topics.
+	if len(m.Header.Get(stomp.HeaderDurable)) != 0 {
+		if !bytes.HasPrefix(m.Dest, routeTopic) {
+			return errDurableTopic
+		}
+		if len(sess.clientID()) == 0 {
+			return errDurableClientID
+		}
+	}
+
 	r.Lock()
 	h, ok := r.destinations[string(m.Dest)]
 	if !ok {
-		h = createHandler(m)
+		h = r.createHandler(m)
 		r.destinations[string(m.Dest)] = h
+
+		// wildcard subscriptions are added to the tree
+		// used to match published topics.
+		if isWildcard(m.Dest) {
+			r.wildcards.insert(m.Dest, h.(*topic))
+		}
+	}
+
+	// temporary queues are owned by the session that creates
+	// the queue, and cannot be read by other sessions.
+	if q, ok := h.(*queue); ok && bytes.HasPrefix(m.Dest, routeTemp) {
+		if q.owner == nil {
+			q.owner = sess
+			sess.temp[string(m.Dest)] = q
+		}
+		if q.owner != sess {
+			r.Unlock()
+			return errTempQueue
+		}
 	}
 	r.Unlock()
 	return h.subscribe(sess.subs(m), m)
@@ -117,21 +275,45 @@ func (r *router) unsubscribe(sess *session, m *stomp.Message) (err error) {
 	return h.unsubscribe(sub, m)
 }
 
+// ack acknowledges the message. If the subscription uses cumulative
+// acknowledgement all earlier messages are also acknowledged.
 func (r *router) ack(sess *session, m *stomp.Message) {
 	sess.Lock()
-	ack, ok := sess.ack[string(m.ID)]
-	delete(sess.ack, string(m.ID))
+	ids := sess.acks(string(m.ID))
 	sess.Unlock()
 
-	if ok {
-		logger.Verbosef("stomp: ack %s: successful",
-			string(m.ID),
-		)
-	} else {
-		logger.Noticef("stomp: ack %s: message not found",
-			string(m.ID),
-		)
+	for _, id := range ids {
+		r.acknowledge(sess, id)
 	}
+}
+
+// nack negative-acknowledges the message. If the subscription uses
+// cumulative acknowledgement all earlier messages are also negative
+// acknowledged.
+func (r *router) nack(sess *session, m *stomp.Message) {
+	sess.Lock()
+	ids := sess.acks(string(m.ID))
+	sess.Unlock()
+
+	for _, id := range ids {
+		r.reject(sess, id)
+	}
+}
+
+// acknowledge acknowledges the individual message.
+func (r *router) acknowledge(sess *session, id string) {
+	sess.Lock()
+	ack, ok := sess.ack[id]
+	delete(sess.ack, id)
+	sess.unlease(id)
+	sess.Unlock()
+
+	if !ok {
+		logger.Noticef("stomp: ack %s: message not found", id)
+		return
+	}
+	logger.Verbosef("stomp: ack %s: successful", id)
+	r.metrics.dest(string(ack.Dest)).incr(statAcked)
 
 	// if the subscription is still active, check the prefetch
 	// count and decrement pending prefetches.
@@ -155,25 +337,28 @@ func (r *router) ack(sess *session, m *stomp.Message) {
 		}
 	}
 
-	// if r.storage != nil {
-	// 	r.storage.delete(m)
-	// }
+	// if the message was persisted it can be removed from storage
+	// now that it has been acknowledged.
+	if shouldPersist(ack) && r.storage != nil {
+		r.storage.delete(ack)
+	}
 }
 
-func (r *router) nack(sess *session, m *stomp.Message) {
+// reject negative-acknowledges the individual message, which
+// is redelivered.
+func (r *router) reject(sess *session, id string) {
 	sess.Lock()
-	nack, ok := sess.ack[string(m.ID)]
-	delete(sess.ack, string(m.ID))
+	nack, ok := sess.ack[id]
+	delete(sess.ack, id)
+	sess.unlease(id)
 
-	if ok {
-		logger.Verbosef("stomp: nack %s: successful",
-			string(m.ID),
-		)
-	} else {
-		logger.Noticef("stomp: nack %s: message not found",
-			string(m.ID),
-		)
+	if !ok {
+		sess.Unlock()
+		logger.Noticef("stomp: nack %s: message not found", id)
+		return
 	}
+	logger.Verbosef("stomp: nack %s: successful", id)
+	r.metrics.dest(string(nack.Dest)).incr(statNacked)
 
 	// if the subscription is still active, check the prefetch
 	// count and decrement pending prefetches.
@@ -183,14 +368,49 @@ func (r *router) nack(sess *session, m *stomp.Message) {
 	}
 	sess.Unlock()
 
-	if ok {
-		nack.ID = m.Ack
-		nack.Ack = m.Ack[:0]
-		r.publish(nack)
+	nack.Ack = nack.Ack[:0]
+	r.redeliver(nack)
+}
+
+// expire redelivers an unacknowledged message when the
+// subscription ack timeout is exceeded.
+func (r *router) expire(sess *session, id string) {
+	logger.Noticef("stomp: ack %s: timeout exceeded", id)
+	r.reject(sess, id)
+}
+
+// extend resets the ack timeout of an unacknowledged message,
+// allowing long running consumers to keep the message.
+func (r *router) extend(sess *session, m *stomp.Message) {
+	sess.Lock()
+	defer sess.Unlock()
+
+	ack, ok := sess.ack[string(m.ID)]
+	if !ok {
+		logger.Noticef("stomp: extend %s: message not found",
+			string(m.ID),
+		)
+		return
+	}
+	timer, ok := sess.timers[string(m.ID)]
+	if !ok {
+		return
+	}
+	if sub, ok := sess.sub[string(ack.Subs)]; ok {
+		timer.Reset(sub.timeout)
 	}
 }
 
 func (r *router) disconnect(sess *session) {
+	// pending transactions are aborted when the
+	// session is disconnected.
+	for id, tx := range sess.tx {
+		for _, m := range tx {
+			m.Release()
+		}
+		delete(sess.tx, id)
+	}
+
 	for _, sub := range sess.sub {
 		r.Lock()
 		h, ok := r.destinations[string(sub.dest)]
@@ -199,15 +419,38 @@ func (r *router) disconnect(sess *session) {
 			continue
 		}
 		h.disconnect(sess)
+		if _, ok := sess.temp[string(sub.dest)]; ok {
+			continue
+		}
 		r.collect(h)
 	}
 
-	for _, m := range sess.ack {
-		delete(sess.ack, string(m.Ack))
+	// temporary queues owned by the session are deleted, discarding
+	// any pending messages, including queues that the session is no
+	// longer subscribed to.
+	for dest, q := range sess.temp {
+		r.Lock()
+		if h, ok := r.destinations[dest]; ok && h == handler(q) {
+			delete(r.destinations, dest)
+		}
+		r.Unlock()
+		q.purge()
+	}
+
+	// the unacknowledged messages are removed while holding the
+	// lock to prevent concurrent redelivery by an ack timeout.
+	sess.Lock()
+	var acks []*stomp.Message
+	for id, m := range sess.ack {
+		delete(sess.ack, id)
+		sess.unlease(id)
+		acks = append(acks, m)
+	}
+	sess.Unlock()
 
-		m.ID = m.Ack
+	for _, m := range acks {
 		m.Ack = m.Ack[:0]
-		r.publish(m)
+		r.redeliver(m)
 	}
 
 	r.Lock()
@@ -215,10 +458,68 @@ func (r *router) disconnect(sess *session) {
 	r.Unlock()
 }
 
+// redeliver re-publishes an unacknowledged message and increments the
+// redelivery count. If the message exceeds the maximum redelivery count
+// it is moved to the dead-letter queue.
+func (r *router) redeliver(m *stomp.Message) {
+	count := m.Header.GetInt(string(stomp.HeaderRedelivery)) + 1
+	m.Header.Set(stomp.HeaderRedelivery, strconv.AppendInt(nil, int64(count), 10))
+
+	max := r.redeliveries
+	if v := m.Header.Get(stomp.HeaderRedeliveries); len(v) != 0 {
+		max = stomp.ParseInt(v)
+	}
+
+	if max != 0 && count > max && !bytes.HasPrefix(m.Dest, routeDLQ) {
+		logger.Noticef("stomp: redeliver %s: moved to dead-letter queue: destination %s",
+			string(m.ID),
+			string(m.Dest),
+		)
+		m.Header.Set(stomp.HeaderOrigDest, m.Dest)
+		m.Header.Set(stomp.HeaderReason, []byte(reasonRedeliveries))
+		m.Dest = deadLetter(m.Dest)
+
+		// the persisted message is updated to ensure it is restored
+		// to the dead-letter queue.
+		if shouldPersist(m) && r.storage != nil {
+			r.storage.put(m)
+		}
+	}
+	r.publish(m)
+}
+
+// unsubscribeDurable deletes the durable subscription with the
+// given key, and returns true if the durable subscription existed.
+func (r *router) unsubscribeDurable(key string) (ok bool) {
+	r.RLock()
+	var topics []*topic
+	for _, h := range r.destinations {
+		if t, isTopic := h.(*topic); isTopic {
+			topics = append(topics, t)
+		}
+	}
+	r.RUnlock()
+
+	for _, t := range topics {
+		if t.remove(key) {
+			logger.Noticef("stomp: delete durable subscription %s: destination %s",
+				key,
+				t.destination(),
+			)
+			r.collect(t)
+			ok = true
+		}
+	}
+	return
+}
+
 func (r *router) collect(h handler) {
 	r.Lock()
 	if h.recycle() {
 		delete(r.destinations, h.destination())
+		if isWildcard([]byte(h.destination())) {
+			r.wildcards.remove([]byte(h.destination()))
+		}
 	}
 	r.Unlock()
 }
@@ -231,6 +532,7 @@ func (r *router) serve(session *session) error {
 
 	// the first message from the client should be STOMP
 	if !bytes.Equal(message.Method, stomp.MethodStomp) {
+		session.error(message, errStompMethod)
 		return errStompMethod
 	}
 
@@ -240,9 +542,16 @@ func (r *router) serve(session *session) error {
 	if r.authorizer != nil {
 		err := r.authorizer(message)
 		if err != nil {
+			session.error(message, err)
 			return err
 		}
 	}
+
+	send, recv, err := r.negotiate(message.Heartbeat)
+	if err != nil {
+		session.error(message, err)
+		return err
+	}
 	session.init(message)
 
 	r.Lock()
@@ -254,8 +563,15 @@ func (r *router) serve(session *session) error {
 	connected := stomp.NewMessage()
 	connected.Method = stomp.MethodConnected
 	connected.Proto = stomp.STOMP
+	connected.Heartbeat = r.heartbeat
 	session.send(connected)
 
+	// configure the peer to send and monitor heart-beats using the
+	// intervals negotiated with the client.
+	if h, ok := session.peer.(stomp.Heartbeater); ok {
+		h.Heartbeat(send, recv)
+	}
+
 	for {
 		message, ok := <-session.peer.Receive()
 		if !ok {
@@ -265,13 +581,24 @@ func (r *router) serve(session *session) error {
 		// optional message logging
 		logger.Debugf("stomp: received message from client.\n%s", message)
 
+		var err error
 		switch {
+		case transactional(message):
+			err = r.enlist(session, message)
+		case bytes.Equal(message.Method, stomp.MethodBegin):
+			err = r.begin(session, message)
+		case bytes.Equal(message.Method, stomp.MethodCommit):
+			err = r.commit(session, message)
+		case bytes.Equal(message.Method, stomp.MethodAbort):
+			err = r.abort(session, message)
 		case bytes.Equal(message.Method, stomp.MethodSend):
-			r.publish(message)
+			err = r.send(session, message)
 		case bytes.Equal(message.Method, stomp.MethodSubscribe):
-			r.subscribe(session, message)
+			err = r.subscribe(session, message)
 		case bytes.Equal(message.Method, stomp.MethodUnsubscribe):
-			r.unsubscribe(session, message)
+			err = r.unsubscribe(session, message)
+		case bytes.Equal(message.Method, stomp.MethodAck) && message.Header.GetBool(string(stomp.HeaderExtend)):
+			r.extend(session, message)
 		case bytes.Equal(message.Method, stomp.MethodAck):
 			r.ack(session, message)
 		case bytes.Equal(message.Method, stomp.MethodNack):
@@ -281,6 +608,15 @@ func (r *router) serve(session *session) error {
 			return nil
 		}
 
+		// if the message could not be processed an error is sent
+		// to the client in place of the receipt, and the session is
+		// closed once the error is written, as required by the
+		// protocol.
+		if err != nil {
+			session.error(message, err)
+			message.Release()
+			return err
+		}
 		if len(message.Receipt) != 0 {
 			receipt := stomp.NewMessage()
 			receipt.Method = stomp.MethodRecipet
@@ -291,21 +627,65 @@ func (r *router) serve(session *session) error {
 	}
 }
 
+// negotiate returns the heart-beat send and receive intervals for the
+// session, given the heart-beat header sent by the client. An error is
+// returned if the client sends heart-beats less often than the maximum
+// interval, which prevents a client from delaying the detection of a
+// dead connection indefinitely. The send interval is bounded by the
+// maximum, since sending more often than requested is permitted.
+func (r *router) negotiate(heartbeat []byte) (send, recv time.Duration, err error) {
+	if cx, _ := stomp.ParseHeartbeat(heartbeat); r.heartbeatMax != 0 && cx > r.heartbeatMax {
+		return 0, 0, errHeartbeat
+	}
+	send, recv = stomp.NegotiateHeartbeat(r.heartbeat, heartbeat)
+	if r.heartbeatMax != 0 && send > r.heartbeatMax {
+		send = r.heartbeatMax
+	}
+	return
+}
+
+// shouldPersist returns true if the message should be written to
+// storage. Only queued messages are persisted, excluding temporary
+// queues that do not outlive the session.
 func shouldPersist(m *stomp.Message) bool {
-	return len(m.Persist) != 0 && bytes.Equal(m.Persist, stomp.PersistTrue)
+	return len(m.Persist) != 0 && bytes.Equal(m.Persist, stomp.PersistTrue) &&
+		!bytes.HasPrefix(m.Dest, routeTopic) &&
+		!bytes.HasPrefix(m.Dest, routeTemp)
+}
+
+// deadLetter returns the dead-letter queue for the destination.
+func deadLetter(dest []byte) []byte {
+	name := bytes.TrimPrefix(dest, routeQueue)
+	return append(append([]byte(nil), routeDLQ...), name...)
 }
 
+// shouldCreate returns true if publishing the message should create the
+// destination. Temporary queues are only created by the owning session.
 func shouldCreate(m *stomp.Message) bool {
+	if bytes.HasPrefix(m.Dest, routeTemp) {
+		return false
+	}
 	return bytes.HasPrefix(m.Dest, routeTopic) == false || len(m.Retain) != 0
 }
 
-func createHandler(m *stomp.Message) handler {
+func (r *router) createHandler(m *stomp.Message) handler {
 	switch {
 	case bytes.HasPrefix(m.Dest, routeTopic):
-		return newTopic(m.Dest)
+		t := newTopic(m.Dest)
+		t.stats = r.metrics.dest(string(m.Dest))
+		t.maxCount = r.retainCount
+		t.maxAge = r.retainAge
+		t.maxPending = r.durableLimit
+		return t
 	case bytes.HasPrefix(m.Dest, routeQueue):
-		return newQueue(m.Dest)
+		fallthrough
 	default:
-		return newQueue(m.Dest)
+		q := newQueue(m.Dest)
+		q.storage = r.storage
+		q.stats = r.metrics.dest(string(m.Dest))
+		q.dispatcher = newDispatcher(r.dispatch)
+		q.expire = r.expire
+		q.limit = r.limit(string(m.Dest))
+		return q
 	}
 }
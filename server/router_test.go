@@ -3,8 +3,11 @@ package server
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/drone/mq/stomp"
+
+	"golang.org/x/net/context"
 )
 
 func TestAck(t *testing.T) {
@@ -61,7 +64,7 @@ func TestAckDisconnect(t *testing.T) {
 
 	queue := router.destinations["/queue/test"].(*queue)
 	// verify the queue has a single item
-	if got := queue.list.Len(); got != 1 {
+	if got := queue.len(); got != 1 {
 		t.Errorf("Expect queue has 1 message enqueued. Got %d", got)
 	}
 
@@ -83,7 +86,7 @@ func TestAckDisconnect(t *testing.T) {
 	}
 
 	// verify the queue is empty after popping the item
-	if got := queue.list.Len(); got != 0 {
+	if got := queue.len(); got != 0 {
 		t.Errorf("Expect message received and queue empty. Got %d", got)
 	}
 
@@ -93,7 +96,493 @@ func TestAckDisconnect(t *testing.T) {
 	}
 
 	// the queue should have the message re-added
-	if queue.list.Len() == 1 {
+	if queue.len() == 1 {
 		t.Errorf("Expect message re-added to the queue")
 	}
 }
+
+func TestServeError(t *testing.T) {
+	client, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	router := newRouter()
+	go router.serve(sess)
+
+	conn := stomp.NewMessage()
+	conn.Method = stomp.MethodStomp
+	client.Send(conn)
+	if got := <-client.Receive(); !bytes.Equal(got.Method, stomp.MethodConnected) {
+		t.Fatalf("Expect connected message, got %s", got.Method)
+	}
+
+	unsub := stomp.NewMessage()
+	unsub.Method = stomp.MethodUnsubscribe
+	unsub.ID = []byte("1")
+	unsub.Receipt = []byte("2")
+	client.Send(unsub)
+
+	got := <-client.Receive()
+	if !bytes.Equal(got.Method, stomp.MethodError) {
+		t.Fatalf("Expect error message, got %s", got.Method)
+	}
+	if !bytes.Equal(got.Receipt, []byte("2")) {
+		t.Errorf("Expect error message includes the receipt")
+	}
+	if msg := got.Header.GetString("message"); msg != errNoSubscription.Error() {
+		t.Errorf("Expect error message header %q, got %q", errNoSubscription, msg)
+	}
+	if !bytes.HasPrefix(got.Body, stomp.MethodUnsubscribe) {
+		t.Errorf("Expect error message body includes the failed message")
+	}
+}
+
+func TestServeErrorAuth(t *testing.T) {
+	client, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	router := newRouter()
+	router.authorizer = BasicAuth("janedoe", "password")
+
+	conn := stomp.NewMessage()
+	conn.Method = stomp.MethodStomp
+	conn.User = []byte("janedoe")
+	conn.Pass = []byte("secret")
+	client.Send(conn)
+
+	if err := router.serve(sess); err != ErrNotAuthorized {
+		t.Errorf("Expect not authorized error, got %v", err)
+	}
+
+	got := <-client.Receive()
+	if !bytes.Equal(got.Method, stomp.MethodError) {
+		t.Fatalf("Expect error message, got %s", got.Method)
+	}
+	if bytes.Contains(got.Body, []byte("secret")) {
+		t.Errorf("Expect password removed from the error message body")
+	}
+}
+
+func TestNackDeadLetter(t *testing.T) {
+	client, server := stomp.Pipe()
+
+	sub := stomp.NewMessage()
+	sub.ID = []byte("1")
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClient
+	sess := requestSession()
+	sess.peer = server
+
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/queue/test")
+	msg.Body = []byte("poison")
+	msg.Header.Add([]byte("foo"), []byte("bar"))
+
+	router := newRouter()
+	router.redeliveries = 1
+	router.subscribe(sess, sub)
+	router.publish(msg)
+
+	got := <-client.Receive()
+	nack := stomp.NewMessage()
+	nack.ID = got.Ack
+	router.nack(sess, nack)
+
+	// the message is redelivered once with the redelivery count.
+	got = <-client.Receive()
+	if v := got.Header.GetInt("redelivery-count"); v != 1 {
+		t.Errorf("Expect redelivery count 1, got %d", v)
+	}
+	nack = stomp.NewMessage()
+	nack.ID = got.Ack
+	router.nack(sess, nack)
+
+	h, ok := router.destinations["/queue/DLQ.test"]
+	if !ok {
+		t.Fatalf("Expect message moved to the dead-letter queue")
+	}
+	dlq := h.(*queue)
+	if got := dlq.len(); got != 1 {
+		t.Fatalf("Expect dead-letter queue has 1 message. Got %d", got)
+	}
+	dead := pendingMessages(dlq)[0]
+	if got := dead.Header.GetString("original-destination"); got != "/queue/test" {
+		t.Errorf("Expect original destination header, got %q", got)
+	}
+	if got := dead.Header.GetString("reason"); got != reasonRedeliveries {
+		t.Errorf("Expect dead-letter reason header, got %q", got)
+	}
+	if got := dead.Header.GetString("foo"); got != "bar" {
+		t.Errorf("Expect original headers preserved")
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	client, server := stomp.Pipe()
+
+	sub := stomp.NewMessage()
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClient
+	sub.Header.Add(stomp.HeaderAckTimeout, []byte("250"))
+	sess := requestSession()
+	sess.peer = server
+
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/queue/test")
+	msg.Body = []byte("bonjour")
+
+	router := newRouter()
+	router.subscribe(sess, sub)
+	router.publish(msg)
+
+	got := <-client.Receive()
+	if !bytes.Equal(msg.Body, got.Body) {
+		t.Errorf("Expect message received by subscriber")
+	}
+
+	// the message is not acknowledged and should be
+	// redelivered when the ack timeout is exceeded.
+	select {
+	case got = <-client.Receive():
+		if !bytes.Equal(msg.Body, got.Body) {
+			t.Errorf("Expect message redelivered after ack timeout")
+		}
+		if got.Header.GetInt("redelivery-count") != 1 {
+			t.Errorf("Expect redelivery count incremented after ack timeout")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expect message redelivered after ack timeout")
+	}
+
+	// the ack timeout is extended while the message is
+	// processed and should not be redelivered. The interval
+	// between extensions is a fraction of the timeout, so
+	// that scheduling delays do not expire the message.
+	ext := stomp.NewMessage()
+	ext.ID = got.Ack
+	for i := 0; i < 8; i++ {
+		time.Sleep(time.Millisecond * 50)
+		router.extend(sess, ext)
+	}
+	select {
+	case <-client.Receive():
+		t.Errorf("Expect message not redelivered when ack timeout extended")
+	default:
+	}
+
+	ack := stomp.NewMessage()
+	ack.ID = got.Ack
+	router.ack(sess, ack)
+
+	sess.Lock()
+	if len(sess.timers) != 0 {
+		t.Errorf("Expect ack timeout stopped when message acknowledged")
+	}
+	sess.Unlock()
+}
+
+func TestAckModes(t *testing.T) {
+	var tests = []struct {
+		mode    []byte
+		pending []int
+	}{
+		// acknowledging the second message acknowledges
+		// the first message when acks are cumulative.
+		{stomp.AckClient, []int{2}},
+		{stomp.AckClientIndividual, []int{0, 2}},
+	}
+
+	for _, test := range tests {
+		client, server := stomp.Pipe()
+
+		sub := stomp.NewMessage()
+		sub.ID = []byte("1")
+		sub.Dest = []byte("/queue/test")
+		sub.Ack = test.mode
+		sess := requestSession()
+		sess.peer = server
+
+		router := newRouter()
+		router.subscribe(sess, sub)
+
+		var acks [][]byte
+		for i := 0; i < 3; i++ {
+			msg := stomp.NewMessage()
+			msg.Method = stomp.MethodSend
+			msg.Dest = []byte("/queue/test")
+			router.publish(msg)
+
+			got := <-client.Receive()
+			acks = append(acks, got.Ack)
+		}
+
+		ack := stomp.NewMessage()
+		ack.ID = acks[1]
+		router.ack(sess, ack)
+
+		if got, want := len(sess.ack), len(test.pending); got != want {
+			t.Errorf("Want %d pending acks with ack:%s, got %d", want, test.mode, got)
+		}
+		for _, i := range test.pending {
+			if _, ok := sess.ack[string(acks[i])]; !ok {
+				t.Errorf("Want message %d pending ack with ack:%s", i, test.mode)
+			}
+		}
+	}
+}
+
+func TestDurable(t *testing.T) {
+	router := newRouter()
+	router.durableLimit = 2
+
+	connect := stomp.NewMessage()
+	connect.Method = stomp.MethodStomp
+	connect.User = []byte("janedoe")
+	connect.Header.Add(stomp.HeaderClientID, []byte("dashboard"))
+
+	sub := stomp.NewMessage()
+	sub.Method = stomp.MethodSubscribe
+	sub.Dest = []byte("/topic/builds")
+	sub.ID = []byte("1")
+	sub.Header.Add(stomp.HeaderDurable, []byte("builds"))
+
+	// the subscriber disconnects and messages published while
+	// offline are buffered, up to the limit.
+	_, peer := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = peer
+	sess.init(connect)
+	if err := router.subscribe(sess, sub); err != nil {
+		t.Fatal(err)
+	}
+	router.disconnect(sess)
+
+	for _, body := range []string{"1", "2", "3"} {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodSend
+		m.Dest = []byte("/topic/builds")
+		m.Body = []byte(body)
+		router.publish(m)
+	}
+
+	// a different user with the same client id does not resume
+	// the durable subscription.
+	other := stomp.NewMessage()
+	other.Method = stomp.MethodStomp
+	other.User = []byte("johnsmith")
+	other.Header.Add(stomp.HeaderClientID, []byte("dashboard"))
+
+	client, peer := stomp.Pipe()
+	sess = requestSession()
+	sess.peer = peer
+	sess.init(other)
+	if err := router.subscribe(sess, sub); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.Receive():
+		t.Errorf("Want buffered messages not delivered to another user")
+	default:
+	}
+	router.disconnect(sess)
+	if !router.unsubscribeDurable("johnsmith:dashboard:builds") {
+		t.Errorf("Want separate durable subscription for another user")
+	}
+
+	// the subscriber reconnects and receives the buffered messages.
+	client, peer = stomp.Pipe()
+	sess = requestSession()
+	sess.peer = peer
+	sess.init(connect)
+	if err := router.subscribe(sess, sub); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"2", "3"} {
+		select {
+		case got := <-client.Receive():
+			if string(got.Body) != want {
+				t.Errorf("Want buffered message %s, got %s", want, got.Body)
+			}
+			if !bytes.Equal(got.Subs, sub.ID) {
+				t.Errorf("Want buffered message sent to the subscription")
+			}
+		default:
+			t.Errorf("Want buffered message %s delivered on reconnect", want)
+		}
+	}
+	router.disconnect(sess)
+
+	if !router.unsubscribeDurable("janedoe:dashboard:builds") {
+		t.Errorf("Want durable subscription deleted")
+	}
+	if len(router.destinations) != 0 {
+		t.Errorf("Want topic recycled when the durable subscription is deleted")
+	}
+
+	// durable subscriptions require a client id and topic.
+	sess = requestSession()
+	sess.peer = peer
+	sess.init(stomp.NewMessage())
+	if err := router.subscribe(sess, sub); err != errDurableClientID {
+		t.Errorf("Want error %s, got %v", errDurableClientID, err)
+	}
+	sess.init(connect)
+	sub.Dest = []byte("/queue/builds")
+	if err := router.subscribe(sess, sub); err != errDurableTopic {
+		t.Errorf("Want error %s, got %v", errDurableTopic, err)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	s := NewServer()
+	client := s.Client()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	handler := func(m *stomp.Message) {
+		client.Reply(m, append([]byte("hello "), m.Body...))
+		m.Release()
+	}
+	_, err := client.Subscribe("/queue/greet", stomp.HandlerFunc(handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := client.Request(ctx, "/queue/greet", []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reply.Release()
+	if got := string(reply.Body); got != "hello world" {
+		t.Errorf("Want reply hello world, got %s", got)
+	}
+
+	// a request without a reply times out.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = client.Request(ctx, "/queue/ignored", []byte("world"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want request deadline exceeded, got %v", err)
+	}
+}
+
+func TestTempQueue(t *testing.T) {
+	router := newRouter()
+
+	sub := stomp.NewMessage()
+	sub.Method = stomp.MethodSubscribe
+	sub.Dest = []byte("/temp-queue/1")
+	sub.ID = []byte("1")
+
+	// publishing to a temporary queue that does not
+	// exist discards the message.
+	m := stomp.NewMessage()
+	m.Method = stomp.MethodSend
+	m.Dest = sub.Dest
+	router.publish(m)
+	if _, ok := router.destinations["/temp-queue/1"]; ok {
+		t.Errorf("Want temporary queue not created on publish")
+	}
+
+	_, peer := stomp.Pipe()
+	owner := requestSession()
+	owner.peer = peer
+	if err := router.subscribe(owner, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	other := requestSession()
+	other.peer = peer
+	if err := router.subscribe(other, sub); err != errTempQueue {
+		t.Errorf("Want error %s, got %v", errTempQueue, err)
+	}
+
+	// the temporary queue is deleted when the owner disconnects,
+	// discarding pending messages.
+	router.disconnect(owner)
+	router.publish(m)
+	if _, ok := router.destinations["/temp-queue/1"]; ok {
+		t.Errorf("Want temporary queue deleted when the session disconnects")
+	}
+}
+
+func TestTempQueueUnsubscribed(t *testing.T) {
+	router := newRouter()
+
+	sub := stomp.NewMessage()
+	sub.Method = stomp.MethodSubscribe
+	sub.Dest = []byte("/temp-queue/1")
+	sub.ID = []byte("1")
+	sub.Selector = []byte("ready == 'true'")
+
+	_, peer := stomp.Pipe()
+	owner := requestSession()
+	owner.peer = peer
+	if err := router.subscribe(owner, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	// the message does not match the selector and is pending
+	// when the owner unsubscribes, and the queue is not recycled.
+	m := stomp.NewMessage()
+	m.Method = stomp.MethodSend
+	m.Dest = sub.Dest
+	router.publish(m)
+	if err := router.unsubscribe(owner, sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := router.destinations["/temp-queue/1"]; !ok {
+		t.Fatalf("Want temporary queue with pending messages retained")
+	}
+
+	// the temporary queue is deleted when the owner disconnects,
+	// even though the owner is no longer subscribed.
+	router.disconnect(owner)
+	if _, ok := router.destinations["/temp-queue/1"]; ok {
+		t.Errorf("Want temporary queue deleted when the session disconnects")
+	}
+	owner.release()
+
+	// a pooled session does not retain ownership of
+	// temporary queues owned by the previous client.
+	if sess := requestSession(); len(sess.temp) != 0 {
+		t.Errorf("Want temporary queues cleared when the session is released")
+	}
+}
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	router := newRouter()
+	router.heartbeat = stomp.FormatHeartbeat(time.Second, time.Second)
+	router.heartbeatMax = time.Minute
+
+	// the client cannot send heart-beats less often
+	// than the maximum interval.
+	_, _, err := router.negotiate([]byte("86400000,0"))
+	if err != errHeartbeat {
+		t.Errorf("Want error %s, got %v", errHeartbeat, err)
+	}
+
+	// the send interval is bounded by the maximum.
+	send, recv, err := router.negotiate([]byte("0,86400000"))
+	if err != nil || send != time.Minute || recv != 0 {
+		t.Errorf("Want heart-beat intervals 1m0s,0s, got %s,%s (%v)", send, recv, err)
+	}
+
+	send, recv, err = router.negotiate([]byte("5000,0"))
+	if err != nil || send != 0 || recv != time.Second*5 {
+		t.Errorf("Want heart-beat intervals 0s,5s, got %s,%s (%v)", send, recv, err)
+	}
+
+	router.heartbeatMax = 0
+	send, recv, err = router.negotiate([]byte("86400000,86400000"))
+	if err != nil || send != time.Hour*24 || recv != time.Hour*24 {
+		t.Errorf("Want heart-beat intervals unbounded, got %s,%s (%v)", send, recv, err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/drone/mq/stomp"
+)
+
+// scheduled is a message scheduled for future delivery.
+type scheduled struct {
+	msg *stomp.Message
+	due time.Time
+}
+
+// schedule is a min-heap of scheduled messages ordered by the time
+// the message is due for delivery. It implements heap.Interface.
+type schedule []*scheduled
+
+func (s schedule) Len() int           { return len(s) }
+func (s schedule) Less(i, j int) bool { return s[i].due.Before(s[j].due) }
+func (s schedule) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *schedule) Push(x interface{}) {
+	*s = append(*s, x.(*scheduled))
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*s = old[:n-1]
+	return x
+}
+
+// deliverAt returns the time at which the message should be delivered,
+// or the zero time if the message is not delayed.
+func deliverAt(m *stomp.Message) (t time.Time) {
+	ms := stomp.ParseInt64(m.Header.Get(stomp.HeaderDeliverAt))
+	if ms != 0 {
+		t = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return
+}
+
+// normalizeDelay replaces the relative delay header with the absolute
+// deliver-at header. This ensures the message is not delayed a second
+// time when it is redelivered or restored from storage.
+func normalizeDelay(m *stomp.Message) {
+	delay := m.Header.Get(stomp.HeaderDelay)
+	if len(delay) == 0 {
+		return
+	}
+	m.Header.Del(stomp.HeaderDelay)
+
+	ms := stomp.ParseInt64(delay)
+	if ms == 0 {
+		return
+	}
+	at := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	m.Header.Set(stomp.HeaderDeliverAt,
+		strconv.AppendInt(nil, at.UnixNano()/int64(time.Millisecond), 10),
+	)
+}
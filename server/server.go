@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/drone/mq/logger"
 	"github.com/drone/mq/stomp"
@@ -11,15 +13,62 @@ import (
 	"golang.org/x/net/websocket"
 )
 
+// default limits applied to frames received from the client.
+const (
+	defaultFrameSize  = 1 << 20 // 1MB
+	defaultHeaders    = 1000
+	defaultHeaderSize = 10 << 10 // 10KB
+	defaultSendBuffer = 100
+)
+
 // Server ...
 type Server struct {
 	router *router
+
+	frameSize  int
+	headers    int
+	headerSize int
+
+	sendBuffer   int
+	slowConsumer SlowConsumer
+
+	// storage is the path of the persistent datastore.
+	storage string
 }
 
-// NewServer returns a new STOMP server.
+// NewServer returns a new STOMP server. If the server is configured with
+// persistent storage the persisted messages are restored, and an error
+// loading the datastore is logged. Use Open to handle the error.
 func NewServer(options ...Option) *Server {
+	server := newServer(options...)
+	if server.storage != "" {
+		if err := loadDatastore(server.storage, server.router); err != nil {
+			logger.Warningf("stomp: cannot load datastore. %s", err)
+		}
+	}
+	return server
+}
+
+// Open returns a new STOMP server. If the server is configured with
+// persistent storage the persisted messages are restored, and an error
+// is returned if the datastore cannot be loaded.
+func Open(options ...Option) (*Server, error) {
+	server := newServer(options...)
+	if server.storage != "" {
+		if err := loadDatastore(server.storage, server.router); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+func newServer(options ...Option) *Server {
 	server := &Server{
-		router: newRouter(),
+		router:     newRouter(),
+		frameSize:  defaultFrameSize,
+		headers:    defaultHeaders,
+		headerSize: defaultHeaderSize,
+		sendBuffer: defaultSendBuffer,
 	}
 	for _, option := range options {
 		option(server)
@@ -27,12 +76,27 @@ func NewServer(options ...Option) *Server {
 	return server
 }
 
+// Close closes the persistent datastore, if configured.
+func (s *Server) Close() error {
+	if s.router.storage == nil {
+		return nil
+	}
+	return s.router.storage.close()
+}
+
 // Serve accepts incoming net.Conn requests.
 func (s *Server) Serve(conn net.Conn) {
 	logger.Verbosef("stomp: session opened.")
 
 	session := requestSession()
-	session.peer = stomp.Conn(conn)
+	session.peer = stomp.Conn(conn,
+		stomp.WithMaxFrameSize(s.frameSize),
+		stomp.WithMaxHeaders(s.headers),
+		stomp.WithMaxHeaderSize(s.headerSize),
+	)
+	if s.sendBuffer != 0 {
+		session.start(s.sendBuffer, s.slowConsumer)
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -40,6 +104,7 @@ func (s *Server) Serve(conn net.Conn) {
 		}
 
 		s.router.disconnect(session)
+		session.stop()
 		session.peer.Close()
 		session.release()
 
@@ -70,6 +135,8 @@ func (s *Server) HandleSessions(w http.ResponseWriter, r *http.Request) {
 		Addr    string            `json:"address"`
 		User    string            `json:"username"`
 		Headers map[string]string `json:"headers"`
+		Pending int               `json:"pending"`
+		Dropped int64             `json:"dropped"`
 	}
 
 	var sessions []sessionResp
@@ -84,6 +151,8 @@ func (s *Server) HandleSessions(w http.ResponseWriter, r *http.Request) {
 			Addr:    sess.peer.Addr(),
 			User:    string(sess.msg.User),
 			Headers: headers,
+			Pending: sess.pending(),
+			Dropped: atomic.LoadInt64(&sess.dropped),
 		})
 	}
 	s.router.RUnlock()
@@ -110,6 +179,88 @@ func (s *Server) HandleDests(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(dests)
 }
 
+// HandleDurables writes a JSON-encoded list of durable subscriptions to the
+// http.Request. A DELETE request deletes the durable subscription identified
+// by the id query parameter, discarding the buffered messages. If the server
+// requires authorization, the DELETE request must include the credentials
+// using http basic authentication.
+func (s *Server) HandleDurables(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "DELETE" {
+		if err := s.authorize(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mq"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		id := r.FormValue("id")
+		if !s.router.unsubscribeDurable(id) {
+			http.Error(w, "durable subscription not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type durableResp struct {
+		ID      string     `json:"id"`
+		Dest    string     `json:"destination"`
+		Active  bool       `json:"active"`
+		Pending int        `json:"pending"`
+		Since   *time.Time `json:"offline_since,omitempty"`
+	}
+
+	var durables []durableResp
+	s.router.RLock()
+	for _, h := range s.router.destinations {
+		t, ok := h.(*topic)
+		if !ok {
+			continue
+		}
+		t.RLock()
+		for _, d := range t.durables {
+			resp := durableResp{
+				ID:      d.key,
+				Dest:    d.dest,
+				Active:  d.sub != nil,
+				Pending: d.pending(),
+			}
+			if d.sub == nil {
+				since := d.since
+				resp.Since = &since
+			}
+			durables = append(durables, resp)
+		}
+		t.RUnlock()
+	}
+	s.router.RUnlock()
+
+	json.NewEncoder(w).Encode(durables)
+}
+
+// authorize authorizes the http.Request using the basic authentication
+// credentials and the server authorizer, if any.
+func (s *Server) authorize(r *http.Request) error {
+	if s.router.authorizer == nil {
+		return nil
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return ErrNotAuthorized
+	}
+	m := stomp.NewMessage()
+	defer m.Release()
+	m.Method = stomp.MethodStomp
+	m.User = []byte(user)
+	m.Pass = []byte(pass)
+	return s.router.authorizer(m)
+}
+
+// HandleMetrics writes the server metrics to the http.Request in the
+// prometheus text format.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.router.writeMetrics(w)
+}
+
 // Client returns a stomp.Client that has a direct peer connection
 // to the server.
 func (s *Server) Client() *stomp.Client {
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/drone/mq/stomp"
+)
+
+func TestServeErrorFlush(t *testing.T) {
+	s := NewServer(
+		WithCredentials("janedoe", "password"),
+	)
+
+	// the error frame is buffered before the session is closed,
+	// and must be written to the client before the connection
+	// is closed.
+	for i := 0; i < 20; i++ {
+		a, b := net.Pipe()
+		go s.Serve(b)
+
+		client := stomp.New(stomp.Conn(a))
+		err := client.Connect(stomp.WithCredentials("janedoe", "invalid"))
+		if _, ok := err.(*stomp.Error); !ok {
+			t.Fatalf("Want stomp.Error when authorization fails, got %v", err)
+		}
+		a.Close()
+	}
+}
+
+func TestServeErrorClose(t *testing.T) {
+	s := NewServer()
+
+	a, b := net.Pipe()
+	go s.Serve(b)
+	defer a.Close()
+
+	client := stomp.New(stomp.Conn(a))
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server closes the connection after sending the error,
+	// which is returned to the client by the done channel.
+	client.Unsubscribe([]byte("unknown"))
+	select {
+	case err := <-client.Done():
+		if _, ok := err.(*stomp.Error); !ok {
+			t.Errorf("Want stomp.Error when the connection is closed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want connection closed after an error is sent")
+	}
+}
+
+func TestServeMaxHeartbeat(t *testing.T) {
+	s := NewServer(
+		WithMaxHeartbeat(time.Minute),
+	)
+
+	// the client sends heart-beats less often than the maximum
+	// interval and is rejected.
+	a, b := net.Pipe()
+	go s.Serve(b)
+	defer a.Close()
+
+	client := stomp.New(stomp.Conn(a))
+	err := client.Connect(stomp.WithHeartbeat(time.Hour, 0))
+	if _, ok := err.(*stomp.Error); !ok {
+		t.Errorf("Want stomp.Error when the heart-beat exceeds the maximum, got %v", err)
+	}
+}
+
+func TestHandleDurablesAuth(t *testing.T) {
+	s := NewServer(
+		WithCredentials("janedoe", "password"),
+	)
+
+	var tests = []struct {
+		user, pass string
+		code       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"janedoe", "invalid", http.StatusUnauthorized},
+		{"janedoe", "password", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("DELETE", "/meta/durables?id=client:name", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.pass)
+		}
+		w := httptest.NewRecorder()
+		s.HandleDurables(w, r)
+		if w.Code != test.code {
+			t.Errorf("Want status %d deleting durable subscription, got %d", test.code, w.Code)
+		}
+	}
+}
@@ -2,21 +2,87 @@ package server
 
 import (
 	"bytes"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/drone/mq/logger"
 	"github.com/drone/mq/stomp"
 	"github.com/drone/mq/stomp/selector"
 )
 
+// SlowConsumer defines how messages are handled when the outbound
+// buffer of a session is full.
+type SlowConsumer int
+
+// Slow consumer policies.
+const (
+	// SlowConsumerBlock blocks the sender until the buffer has capacity.
+	SlowConsumerBlock SlowConsumer = iota
+
+	// SlowConsumerDropOldest discards the oldest buffered frame.
+	SlowConsumerDropOldest
+
+	// SlowConsumerDropNewest discards the message being sent.
+	SlowConsumerDropNewest
+
+	// SlowConsumerDisconnect closes the session.
+	SlowConsumerDisconnect
+)
+
+// ParseSlowConsumer returns the slow consumer policy with the given
+// name: block, drop-oldest, drop-newest or disconnect.
+func ParseSlowConsumer(name string) (SlowConsumer, error) {
+	switch name {
+	case "block":
+		return SlowConsumerBlock, nil
+	case "drop-oldest":
+		return SlowConsumerDropOldest, nil
+	case "drop-newest":
+		return SlowConsumerDropNewest, nil
+	case "disconnect":
+		return SlowConsumerDisconnect, nil
+	}
+	return 0, fmt.Errorf("stomp: invalid slow consumer policy %q", name)
+}
+
+// flushTimeout is the maximum time to wait for buffered
+// messages to be written when the session is closed.
+var flushTimeout = time.Second * 5
+
 // session represents a single client session (ie connection)
 type session struct {
 	peer stomp.Peer
 
 	sub map[string]*subscription
 	ack map[string]*stomp.Message
+	tx  map[string][]*stomp.Message
 	msg *stomp.Message
 
+	// temporary queues owned by the session,
+	// which are deleted when it disconnects.
+	temp map[string]*queue
+
+	// timers that expire unacknowledged
+	// messages, keyed by ack id.
+	timers map[string]*time.Timer
+
+	// outbound buffer drained by the session writer. If nil,
+	// messages are sent directly to the peer.
+	out     chan *stomp.Message
+	done    chan struct{}
+	flushed chan struct{}
+	policy  SlowConsumer
+
+	// serializes writes to the outbound buffer so
+	// that evicting a frame preserves the order.
+	wmu sync.Mutex
+
+	// number of messages discarded because the
+	// outbound buffer was full.
+	dropped int64
+
 	sync.Mutex
 }
 
@@ -24,10 +90,233 @@ func (s *session) init(m *stomp.Message) {
 	s.msg = m
 }
 
+// login returns the username used to establish the session.
+func (s *session) login() string {
+	if s.msg == nil {
+		return ""
+	}
+	return string(s.msg.User)
+}
+
+// clientID returns the client id used to establish the session.
+func (s *session) clientID() []byte {
+	if s.msg == nil {
+		return nil
+	}
+	return s.msg.Header.Get(stomp.HeaderClientID)
+}
+
+// start starts the session writer with an outbound buffer of the
+// given size. Messages sent when the buffer is full are handled
+// according to the slow consumer policy.
+func (s *session) start(size int, policy SlowConsumer) {
+	s.out = make(chan *stomp.Message, size)
+	s.done = make(chan struct{})
+	s.flushed = make(chan struct{})
+	s.policy = policy
+	go s.write(s.peer, s.out, s.done, s.flushed)
+}
+
+// stop stops the session writer, waiting until the buffered messages,
+// such as an error sent before the session is closed, are written to
+// the transport or the flush timeout is exceeded.
+func (s *session) stop() {
+	if s.done == nil {
+		return
+	}
+	close(s.done)
+	select {
+	case <-s.flushed:
+	case <-time.After(flushTimeout):
+		logger.Warningf("stomp: cannot flush session buffer. timeout exceeded.")
+	}
+}
+
+// write writes buffered messages to the transport until the
+// session is stopped, and then writes the remaining messages.
+func (s *session) write(peer stomp.Peer, out <-chan *stomp.Message, done, flushed chan struct{}) {
+	defer close(flushed)
+	for {
+		select {
+		case <-done:
+			for {
+				select {
+				case m := <-out:
+					if err := peer.Send(m); err != nil {
+						m.Release()
+					}
+				default:
+					return
+				}
+			}
+		case m := <-out:
+			if err := peer.Send(m); err != nil {
+				m.Release()
+			}
+		}
+	}
+}
+
 // send writes the message to the transport.
 func (s *session) send(m *stomp.Message) {
 	logger.Debugf("stomp: sending message to client.\n%s", m)
-	s.peer.Send(m)
+	if s.out == nil {
+		s.peer.Send(m)
+		return
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	select {
+	case s.out <- m:
+		return
+	default:
+	}
+
+	// the slow consumer policy only applies to topic messages. Other
+	// frames, such as receipts and queue messages, which would be lost
+	// or left unacknowledged, always wait for buffer capacity.
+	if !droppable(m) {
+		s.enqueue(m)
+		return
+	}
+
+	switch s.policy {
+	case SlowConsumerDropNewest:
+		s.drop(m)
+	case SlowConsumerDropOldest:
+		// if the buffer holds no topic message the
+		// sender waits for capacity.
+		s.evict()
+		s.enqueue(m)
+	case SlowConsumerDisconnect:
+		s.drop(m)
+		if err := s.peer.Close(); err == nil {
+			logger.Warningf("stomp: disconnect slow consumer %s.", s.peer.Addr())
+		}
+	default:
+		s.enqueue(m)
+	}
+}
+
+// evict discards the oldest buffered topic message, returning the
+// remaining frames to the buffer in order. The caller must hold the
+// write lock.
+func (s *session) evict() {
+	var buf []*stomp.Message
+	var evicted bool
+	for len(buf) < cap(s.out) {
+		select {
+		case m := <-s.out:
+			if !evicted && droppable(m) {
+				s.drop(m)
+				evicted = true
+			} else {
+				buf = append(buf, m)
+			}
+			continue
+		default:
+		}
+		break
+	}
+	for _, m := range buf {
+		s.enqueue(m)
+	}
+}
+
+// enqueue adds the message to the outbound buffer, waiting
+// for capacity or for the session to be stopped.
+func (s *session) enqueue(m *stomp.Message) {
+	select {
+	case s.out <- m:
+	case <-s.done:
+		m.Release()
+	}
+}
+
+// droppable returns true if the message may be discarded
+// by the slow consumer policy.
+func droppable(m *stomp.Message) bool {
+	return bytes.Equal(m.Method, stomp.MethodMessage) &&
+		bytes.HasPrefix(m.Dest, routeTopic)
+}
+
+// drop discards the message.
+func (s *session) drop(m *stomp.Message) {
+	atomic.AddInt64(&s.dropped, 1)
+	m.Release()
+}
+
+// pending returns the number of buffered messages.
+func (s *session) pending() int {
+	return len(s.out)
+}
+
+// error writes an error message to the transport. The error message
+// includes the receipt, if requested, and the message that caused the
+// error in the body.
+func (s *session) error(m *stomp.Message, err error) {
+	e := stomp.NewMessage()
+	e.Method = stomp.MethodError
+	e.Receipt = m.Receipt
+	e.Header.Add(stomp.HeaderMessage, []byte(err.Error()))
+	// the password is removed to prevent it being echoed
+	// back to the client in the message body.
+	c := m.Copy()
+	c.Pass = nil
+	e.Body = c.Bytes()
+	c.Release()
+	s.send(e)
+}
+
+// acks returns the ids of the messages acknowledged by the given ack
+// id. If the subscription uses cumulative acknowledgement this includes
+// all unacknowledged messages delivered before it. The caller must hold
+// the lock.
+func (s *session) acks(id string) []string {
+	m, ok := s.ack[id]
+	if !ok {
+		return []string{id}
+	}
+	sub, ok := s.sub[string(m.Subs)]
+	if !ok || !sub.cumulative {
+		return []string{id}
+	}
+	for i, ack := range sub.acks {
+		if ack != id {
+			continue
+		}
+		var ids []string
+		for _, ack := range sub.acks[:i+1] {
+			// messages that were redelivered after
+			// the ack timeout are ignored.
+			if _, ok := s.ack[ack]; ok {
+				ids = append(ids, ack)
+			}
+		}
+		sub.acks = append(sub.acks[:0], sub.acks[i+1:]...)
+		return ids
+	}
+	return []string{id}
+}
+
+// lease starts a timer that invokes the expire function if the
+// message is not acknowledged within the timeout. The caller must
+// hold the lock.
+func (s *session) lease(id string, timeout time.Duration, expire func(*session, string)) {
+	s.timers[id] = time.AfterFunc(timeout, func() {
+		expire(s, id)
+	})
+}
+
+// unlease stops the ack timeout timer, if any. The
+// caller must hold the lock.
+func (s *session) unlease(id string) {
+	if timer, ok := s.timers[id]; ok {
+		timer.Stop()
+		delete(s.timers, id)
+	}
 }
 
 // create a subscription for the current session using the
@@ -36,10 +325,20 @@ func (s *session) subs(m *stomp.Message) *subscription {
 	sub := requestSubscription()
 	sub.id = m.ID
 	sub.dest = m.Dest
-	sub.ack = bytes.Equal(m.Ack, stomp.AckClient) || len(m.Prefetch) != 0
+	sub.ack = bytes.Equal(m.Ack, stomp.AckClient) ||
+		bytes.Equal(m.Ack, stomp.AckClientIndividual) ||
+		len(m.Prefetch) != 0
+	sub.cumulative = bytes.Equal(m.Ack, stomp.AckClient)
 	sub.prefetch = stomp.ParseInt(m.Prefetch)
+	sub.priority = m.Header.GetInt(string(stomp.HeaderConsumerPri))
+	sub.exclusive = m.Header.GetBool(string(stomp.HeaderExclusive))
+	sub.timeout = time.Duration(m.Header.GetInt64(string(stomp.HeaderAckTimeout))) * time.Millisecond
 	sub.session = s
 
+	if name := m.Header.Get(stomp.HeaderDurable); len(name) != 0 {
+		sub.durable = durableKey(s.login(), s.clientID(), name)
+	}
+
 	if len(m.Selector) != 0 {
 		sub.selector, _ = selector.Parse(m.Selector)
 	}
@@ -59,12 +358,26 @@ func (s *session) unsub(sub *subscription) {
 func (s *session) reset() {
 	s.msg = nil
 	s.peer = nil
+	s.out = nil
+	s.done = nil
+	s.flushed = nil
+	s.policy = SlowConsumerBlock
+	s.dropped = 0
 	for id := range s.sub {
 		delete(s.sub, id)
 	}
 	for id := range s.ack {
 		delete(s.ack, id)
 	}
+	for id := range s.tx {
+		delete(s.tx, id)
+	}
+	for dest := range s.temp {
+		delete(s.temp, dest)
+	}
+	for id := range s.timers {
+		s.unlease(id)
+	}
 }
 
 // release releases the session to the pool.
@@ -83,6 +396,10 @@ func createSession() interface{} {
 	return &session{
 		sub: make(map[string]*subscription),
 		ack: make(map[string]*stomp.Message),
+		tx:  make(map[string][]*stomp.Message),
+
+		temp:   make(map[string]*queue),
+		timers: make(map[string]*time.Timer),
 	}
 }
 
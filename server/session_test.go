@@ -2,7 +2,9 @@ package server
 
 import (
 	"bytes"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/drone/mq/stomp"
 )
@@ -92,3 +94,233 @@ func Test_session_pool(t *testing.T) {
 	}
 	s.release()
 }
+
+// slowPeer is a stomp.Peer that blocks sending until
+// the test reads from the sent channel.
+type slowPeer struct {
+	sent   chan *stomp.Message
+	closed chan bool
+}
+
+func newSlowPeer() *slowPeer {
+	return &slowPeer{
+		sent:   make(chan *stomp.Message),
+		closed: make(chan bool),
+	}
+}
+
+func (p *slowPeer) Send(m *stomp.Message) error {
+	select {
+	case p.sent <- m:
+		return nil
+	case <-p.closed:
+		return io.EOF
+	}
+}
+
+func (p *slowPeer) Close() error {
+	select {
+	case <-p.closed:
+		return io.EOF
+	default:
+		close(p.closed)
+		return nil
+	}
+}
+
+func (p *slowPeer) Receive() <-chan *stomp.Message { return nil }
+func (p *slowPeer) Addr() string                   { return "127.0.0.1" }
+
+func Test_session_slowConsumer(t *testing.T) {
+	var tests = []struct {
+		policy  SlowConsumer
+		want    []string
+		dropped int64
+		closed  bool
+	}{
+		{SlowConsumerDropNewest, []string{"1", "2"}, 1, false},
+		{SlowConsumerDropOldest, []string{"1", "3"}, 1, false},
+		{SlowConsumerDisconnect, []string{"1"}, 1, true},
+	}
+
+	for _, test := range tests {
+		peer := newSlowPeer()
+		sess := requestSession()
+		sess.peer = peer
+		sess.start(1, test.policy)
+
+		// the first message is held by the session writer while
+		// the peer blocks. The second message fills the buffer.
+		for _, body := range []string{"1", "2", "3"} {
+			m := stomp.NewMessage()
+			m.Method = stomp.MethodMessage
+			m.Dest = []byte("/topic/test")
+			m.Body = []byte(body)
+			sess.send(m)
+			for body == "1" && sess.pending() != 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		if got := sess.dropped; got != test.dropped {
+			t.Errorf("Want %d dropped messages, got %d", test.dropped, got)
+		}
+
+		select {
+		case <-peer.closed:
+			if !test.closed {
+				t.Errorf("Want slow consumer remains connected")
+			}
+		default:
+			if test.closed {
+				t.Errorf("Want slow consumer disconnected")
+			}
+		}
+
+		if !test.closed {
+			for _, want := range test.want {
+				got := <-peer.sent
+				if string(got.Body) != want {
+					t.Errorf("Want message %s sent to peer, got %s", want, got.Body)
+				}
+			}
+		}
+
+		sess.stop()
+		sess.release()
+	}
+}
+
+func Test_session_slowConsumerQueue(t *testing.T) {
+	policies := []SlowConsumer{
+		SlowConsumerDropNewest,
+		SlowConsumerDropOldest,
+		SlowConsumerDisconnect,
+	}
+
+	for _, policy := range policies {
+		peer := newSlowPeer()
+		sess := requestSession()
+		sess.peer = peer
+		sess.start(1, policy)
+
+		// queue messages are never discarded, since they would be
+		// lost or left unacknowledged, and wait for buffer capacity.
+		done := make(chan bool)
+		go func() {
+			for _, body := range []string{"1", "2", "3"} {
+				m := stomp.NewMessage()
+				m.Method = stomp.MethodMessage
+				m.Dest = []byte("/queue/test")
+				m.Body = []byte(body)
+				sess.send(m)
+			}
+			close(done)
+		}()
+
+		for _, want := range []string{"1", "2", "3"} {
+			got := <-peer.sent
+			if string(got.Body) != want {
+				t.Errorf("Want queue message %s sent to peer, got %s", want, got.Body)
+			}
+		}
+		<-done
+
+		if sess.dropped != 0 {
+			t.Errorf("Want no dropped queue messages, got %d", sess.dropped)
+		}
+		select {
+		case <-peer.closed:
+			t.Errorf("Want slow consumer of queue messages remains connected")
+		default:
+		}
+
+		sess.stop()
+		sess.release()
+	}
+}
+
+func Test_session_slowConsumerEvict(t *testing.T) {
+	peer := newSlowPeer()
+	sess := requestSession()
+	sess.peer = peer
+	sess.start(2, SlowConsumerDropOldest)
+	defer sess.release()
+	defer sess.stop()
+
+	// the first message is held by the session writer while the
+	// peer blocks. The queue message is buffered ahead of the topic
+	// message, which is the oldest frame that can be discarded.
+	for _, body := range []string{"1", "2", "3", "4"} {
+		m := stomp.NewMessage()
+		m.Method = stomp.MethodMessage
+		m.Dest = []byte("/topic/test")
+		if body == "2" {
+			m.Dest = []byte("/queue/test")
+		}
+		m.Body = []byte(body)
+		sess.send(m)
+		for body == "1" && sess.pending() != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for _, want := range []string{"1", "2", "4"} {
+		got := <-peer.sent
+		if string(got.Body) != want {
+			t.Errorf("Want message %s sent to peer, got %s", want, got.Body)
+		}
+	}
+	if sess.dropped != 1 {
+		t.Errorf("Want 1 dropped topic message, got %d", sess.dropped)
+	}
+}
+
+func Test_session_slowConsumerBlock(t *testing.T) {
+	peer := newSlowPeer()
+	sess := requestSession()
+	sess.peer = peer
+	sess.start(1, SlowConsumerBlock)
+	defer sess.release()
+	defer sess.stop()
+
+	done := make(chan bool)
+	go func() {
+		for _, body := range []string{"1", "2", "3"} {
+			m := stomp.NewMessage()
+			m.Method = stomp.MethodMessage
+			m.Body = []byte(body)
+			sess.send(m)
+		}
+		close(done)
+	}()
+
+	for _, want := range []string{"1", "2", "3"} {
+		got := <-peer.sent
+		if string(got.Body) != want {
+			t.Errorf("Want message %s sent to peer, got %s", want, got.Body)
+		}
+	}
+	<-done
+
+	if sess.dropped != 0 {
+		t.Errorf("Want no dropped messages when the policy blocks")
+	}
+}
+
+func Test_ParseSlowConsumer(t *testing.T) {
+	var tests = map[string]SlowConsumer{
+		"block":       SlowConsumerBlock,
+		"drop-oldest": SlowConsumerDropOldest,
+		"drop-newest": SlowConsumerDropNewest,
+		"disconnect":  SlowConsumerDisconnect,
+	}
+	for name, want := range tests {
+		if got, err := ParseSlowConsumer(name); err != nil || got != want {
+			t.Errorf("Want policy %s parsed", name)
+		}
+	}
+	if _, err := ParseSlowConsumer("invalid"); err == nil {
+		t.Errorf("Want error parsing invalid policy")
+	}
+}
@@ -18,8 +18,15 @@ type datastore struct {
 	db *leveldb.DB
 }
 
+// put writes the message to the datastore. The message is encoded as
+// a send frame, regardless of the method, to ensure the persist and
+// expires headers are included.
 func (d *datastore) put(m *stomp.Message) error {
-	return d.db.Put(m.ID, m.Bytes(), nil)
+	c := m.Copy()
+	c.Method = stomp.MethodSend
+	err := d.db.Put(m.ID, c.Bytes(), nil)
+	c.Release()
+	return err
 }
 
 func (d *datastore) delete(m *stomp.Message) error {
@@ -32,21 +39,41 @@ func (d *datastore) close() error {
 
 // loadDatastore reads the datastore from disk and restores
 // persisted message to the appropriate queues.
-func loadDatastore(path string, b *router) (store, error) {
+func loadDatastore(path string, r *router) error {
 	db, err := leveldb.RecoverFile(path, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	r.storage = &datastore{db: db}
 
 	// iterate through the persisted messages
 	// and send to the broker.
 	iter := db.NewIterator(nil, nil)
 	for iter.Next() {
+		// the iterator re-uses the underlying buffers and the
+		// parsed message references the raw bytes, so we must
+		// copy before parsing.
+		raw := append([]byte(nil), iter.Value()...)
+
 		m := stomp.NewMessage()
-		m.Parse(iter.Value())
-		b.publish(m)
+		if err := m.Parse(raw); err != nil {
+			m.Release()
+			continue
+		}
+		// the message id is not included in the encoded message,
+		// so we restore it from the key. The method is set to
+		// message to indicate it has already been persisted.
+		m.ID = append([]byte(nil), iter.Key()...)
+		m.Method = stomp.MethodMessage
+		r.publish(m)
+		m.Release()
 	}
 	iter.Release()
 
-	return &datastore{db: db}, nil
+	if err := iter.Error(); err != nil {
+		r.storage = nil
+		db.Close()
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/drone/mq/stomp"
+)
+
+func Test_datastore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	router := newRouter()
+	if err := loadDatastore(dir, router); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"hello", "hola", "bonjour"} {
+		msg := stomp.NewMessage()
+		msg.Method = stomp.MethodSend
+		msg.Dest = []byte("/queue/test")
+		msg.Persist = stomp.PersistTrue
+		msg.Body = []byte(body)
+		router.publish(msg)
+	}
+
+	// the datastore is re-opened to simulate a server restart,
+	// after which the message should be restored to the queue.
+	// options applied after the storage option are used
+	// by the restored queues.
+	router.storage.close()
+	s, err := Open(
+		WithStorage(dir),
+		WithQueueLimit(QueueLimit{MaxLength: 10}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	router = s.router
+
+	h, ok := router.destinations["/queue/test"]
+	if !ok {
+		t.Fatalf("Expect persisted message restored to queue")
+	}
+	queue := h.(*queue)
+	if got := queue.len(); got != 3 {
+		t.Fatalf("Expect queue has 3 messages restored. Got %d", got)
+	}
+	if queue.limit.MaxLength != 10 {
+		t.Errorf("Expect restored queue configured with the queue limit")
+	}
+
+	client, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	sub := stomp.NewMessage()
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClientIndividual
+	router.subscribe(sess, sub)
+
+	// the restored backlog is delivered to the subscriber.
+	var acks [][]byte
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-client.Receive():
+			acks = append(acks, got.Ack)
+		default:
+			t.Fatalf("Expect restored message %d received by subscriber", i+1)
+		}
+	}
+
+	for _, id := range acks {
+		ack := stomp.NewMessage()
+		ack.ID = id
+		router.ack(sess, ack)
+	}
+
+	db := router.storage.(*datastore).db
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Errorf("Expect messages removed from storage when acknowledged")
+	}
+}
+
+func TestStorageError(t *testing.T) {
+	file, err := ioutil.TempFile("", "mq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+
+	// the datastore path is a file, which cannot be opened.
+	if _, err := Open(WithStorage(file.Name())); err == nil {
+		t.Errorf("Expect error when the datastore cannot be loaded")
+	}
+}
+
+func Test_datastore_deadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	router := newRouter()
+	router.redeliveries = 1
+	if err := loadDatastore(dir, router); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	sub := stomp.NewMessage()
+	sub.Dest = []byte("/queue/test")
+	sub.Ack = stomp.AckClientIndividual
+	router.subscribe(sess, sub)
+
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/queue/test")
+	msg.Persist = stomp.PersistTrue
+	msg.Body = []byte("hello")
+	router.publish(msg)
+
+	// the message is negative-acknowledged until it exceeds
+	// the maximum redeliveries and is moved to the dead-letter
+	// queue.
+	for i := 0; i < 2; i++ {
+		got := <-client.Receive()
+		nack := stomp.NewMessage()
+		nack.ID = got.Ack
+		router.nack(sess, nack)
+	}
+
+	router.storage.close()
+	router = newRouter()
+	if err := loadDatastore(dir, router); err != nil {
+		t.Fatal(err)
+	}
+	defer router.storage.close()
+
+	h, ok := router.destinations["/queue/DLQ.test"]
+	if !ok || h.pending() != 1 {
+		t.Fatalf("Expect persisted message restored to the dead-letter queue")
+	}
+
+	sess = requestSession()
+	sess.peer = server
+	sub.Dest = []byte("/queue/DLQ.test")
+	router.subscribe(sess, sub)
+
+	got := <-client.Receive()
+	ack := stomp.NewMessage()
+	ack.ID = got.Ack
+	router.ack(sess, ack)
+
+	db := router.storage.(*datastore).db
+	if _, err := db.Get(got.ID, nil); err == nil {
+		t.Errorf("Expect dead-lettered message removed from storage when acknowledged")
+	}
+}
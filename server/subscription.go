@@ -2,6 +2,7 @@ package server
 
 import (
 	"sync"
+	"time"
 
 	"github.com/drone/mq/stomp/selector"
 )
@@ -11,13 +12,25 @@ import (
 type subscription struct {
 	mu sync.Mutex
 
-	id       []byte
-	dest     []byte
-	ack      bool
-	prefetch int
-	pending  int
-	session  *session
-	selector *selector.Selector
+	id        []byte
+	dest      []byte
+	ack       bool
+	prefetch  int
+	pending   int
+	priority  int
+	exclusive bool
+	timeout   time.Duration
+
+	// durable is the durable subscription key, if any.
+	durable string
+
+	// cumulative indicates an ack acknowledges all earlier
+	// messages, and acks lists the unacknowledged message
+	// ack ids in delivery order.
+	cumulative bool
+	acks       []string
+	session    *session
+	selector   *selector.Selector
 }
 
 // reset the subscription properties to zero values.
@@ -27,6 +40,12 @@ func (s *subscription) reset() {
 	s.ack = false
 	s.prefetch = 0
 	s.pending = 0
+	s.priority = 0
+	s.exclusive = false
+	s.timeout = 0
+	s.durable = ""
+	s.cumulative = false
+	s.acks = s.acks[:0]
 	s.session = nil
 	s.selector = nil
 }
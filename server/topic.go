@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"sync"
+	"time"
 
 	"github.com/drone/mq/stomp"
 )
@@ -14,22 +15,106 @@ type topic struct {
 	sync.RWMutex
 
 	dest []byte
-	hist []*stomp.Message
+	hist []retained
 	subs map[*subscription]struct{}
+
+	// durables maps the durable subscriptions by key, and
+	// maxPending bounds the messages buffered for each
+	// offline durable subscription.
+	durables   map[string]*durable
+	maxPending int
+
+	// maxCount and maxAge bound the retained messages.
+	// A zero value indicates the retention is unlimited.
+	maxCount int
+	maxAge   time.Duration
+
+	stats *stats
+}
+
+// retained is a message retained by the topic
+// and the time the message was published.
+type retained struct {
+	msg *stomp.Message
+	at  time.Time
 }
 
 func newTopic(dest []byte) *topic {
 	return &topic{
-		dest: dest,
-		subs: make(map[*subscription]struct{}),
+		dest:     dest,
+		subs:     make(map[*subscription]struct{}),
+		durables: make(map[string]*durable),
 	}
 }
 
 // publishes a copy of the message to the subsciber list.
-// If the message includes the retain:true headers the message is
-// saved for future use. If the message includes retain:remove the
-// previously retained message is set to nil.
+// If the message includes the retain:true or retain:last headers
+// the message replaces the retained messages. If the message includes
+// retain:all, or a count such as retain:10, the message is appended
+// to the retained messages. If the message includes retain:remove
+// the retained messages are removed.
 func (t *topic) publish(m *stomp.Message) error {
+	t.deliver(m)
+
+	// if a message has the retain header set we should either
+	// retain the message, or remove the existing retained message.
+	if len(m.Retain) != 0 {
+		now := time.Now()
+
+		t.Lock()
+		switch {
+		case bytes.Equal(m.Retain, stomp.RetainTrue),
+			bytes.Equal(m.Retain, stomp.RetainLast):
+			t.retain(m.Copy(), now, 1)
+		case bytes.Equal(m.Retain, stomp.RetainAll):
+			t.retain(m.Copy(), now, 0)
+		case bytes.Equal(m.Retain, stomp.RetainRemove):
+			t.hist = t.hist[:0]
+		default:
+			if n := stomp.ParseInt(m.Retain); n > 0 {
+				t.retain(m.Copy(), now, n)
+			}
+		}
+		t.prune(now)
+		t.Unlock()
+	}
+
+	return nil
+}
+
+// retain appends the message to the retained messages, keeping at
+// most the given count of messages, or if zero, the maximum count
+// configured for the topic. The caller must hold the lock.
+func (t *topic) retain(m *stomp.Message, now time.Time, count int) {
+	t.hist = append(t.hist, retained{msg: m, at: now})
+
+	if t.maxCount != 0 && (count == 0 || count > t.maxCount) {
+		count = t.maxCount
+	}
+	if count != 0 && len(t.hist) > count {
+		t.hist = append(t.hist[:0], t.hist[len(t.hist)-count:]...)
+	}
+}
+
+// prune removes retained messages that are expired, or that are
+// older than the maximum age configured for the topic. The caller
+// must hold the lock.
+func (t *topic) prune(now time.Time) {
+	hist := t.hist[:0]
+	for _, r := range t.hist {
+		if t.maxAge != 0 && now.Sub(r.at) > t.maxAge {
+			continue
+		}
+		if len(r.msg.Expires) != 0 && stomp.ParseInt64(r.msg.Expires) < now.Unix() {
+			continue
+		}
+		hist = append(hist, r)
+	}
+	t.hist = hist
+}
+
+// deliver sends a copy of the message to the subscriber list.
+func (t *topic) deliver(m *stomp.Message) {
 	id := stomp.Rand()
 
 	t.RLock()
@@ -43,74 +128,146 @@ func (t *topic) publish(m *stomp.Message) error {
 		c.ID = id
 		c.Method = stomp.MethodMessage
 		c.Subs = sub.id
+		t.stats.incr(statDelivered)
 		sub.session.send(c)
 	}
-	t.RUnlock()
-
-	// if a message has the retain header set we should either
-	// retain the message, or remove the existing retained message.
-	if len(m.Retain) != 0 {
-		c := m.Copy()
 
-		t.Lock()
-		switch {
-		case bytes.Equal(m.Retain, stomp.RetainLast):
-			if len(t.hist) == 1 {
-				t.hist[0] = c
-			} else {
-				t.hist = t.hist[:0]
-				t.hist = append(t.hist, c)
+	// the message is buffered for offline durable subscribers
+	// and delivered when the subscriber reconnects.
+	for _, d := range t.durables {
+		if d.sub != nil {
+			continue
+		}
+		if d.selector != nil {
+			if ok, _ := d.selector.Eval(m.Header); !ok {
+				continue
 			}
-		case bytes.Equal(m.Retain, stomp.RetainAll):
-			t.hist = append(t.hist, c)
-		case bytes.Equal(m.Retain, stomp.RetainRemove):
-			t.hist = t.hist[:0]
 		}
-		t.Unlock()
+		c := m.Copy()
+		c.ID = id
+		c.Method = stomp.MethodMessage
+		if d.buffer(c, t.maxPending) {
+			t.stats.incr(statDropped)
+		}
 	}
-
-	return nil
+	t.RUnlock()
 }
 
 // registers the subscription with the topic broker and
-// sends the last retained message, if one exists.
+// sends the last retained message, if one exists. If the
+// subscription resumes a durable subscription the buffered
+// messages are sent instead.
 func (t *topic) subscribe(s *subscription, m *stomp.Message) error {
 	t.Lock()
+	if len(s.durable) != 0 {
+		if d, ok := t.durables[s.durable]; ok {
+			t.Unlock()
+			t.resume(d, s)
+			return nil
+		}
+		t.durables[s.durable] = &durable{
+			key:  s.durable,
+			dest: string(t.dest),
+			sub:  s,
+		}
+	}
 	t.subs[s] = struct{}{}
-	t.Unlock()
-
-	t.RLock()
-	hist := make([]*stomp.Message, len(t.hist))
+	t.prune(time.Now())
+	hist := make([]retained, len(t.hist))
 	copy(hist, t.hist)
-	t.RUnlock()
+	t.Unlock()
 
-	for _, m := range hist {
-		c := m.Copy()
+	for _, r := range hist {
+		c := r.msg.Copy()
 		c.Method = stomp.MethodMessage
 		c.Subs = s.id
 		c.ID = stomp.Rand()
+		t.stats.incr(statDelivered)
 		s.session.send(c)
 	}
 
 	return nil
 }
 
+// resume sends the buffered messages and attaches the subscription to
+// the durable subscription. The messages are sent without holding the
+// lock, so that a slow subscriber does not block publishers. Messages
+// published in the meantime are buffered, and the subscription is only
+// attached once the buffer is empty, preserving the message order. If
+// the durable subscription is active in another session, for example
+// when the client reconnects before the broker detects the broken
+// connection, the subscription takes over.
+func (t *topic) resume(d *durable, s *subscription) {
+	t.Lock()
+	for {
+		if d.sub != nil {
+			delete(t.subs, d.sub)
+			d.sub = nil
+		}
+		msgs := d.flush()
+		if len(msgs) == 0 {
+			break
+		}
+		t.Unlock()
+
+		for _, c := range msgs {
+			c.Subs = s.id
+			t.stats.incr(statDelivered)
+			s.session.send(c)
+		}
+		t.Lock()
+	}
+	d.sub = s
+	d.selector = nil
+	t.subs[s] = struct{}{}
+	t.Unlock()
+}
+
+// unsubscribe removes the subscription. Unsubscribing
+// a durable subscription deletes the buffered messages.
 func (t *topic) unsubscribe(s *subscription, m *stomp.Message) error {
 	t.Lock()
 	delete(t.subs, s)
+	if d, ok := t.durables[s.durable]; ok && d.sub == s {
+		delete(t.durables, s.durable)
+		d.release()
+	}
 	t.Unlock()
 	return nil
 }
 
+// disconnect removes the session subscriptions. Durable
+// subscriptions are retained and buffer messages until
+// the subscriber reconnects.
 func (t *topic) disconnect(s *session) error {
+	now := time.Now()
+
 	t.Lock()
 	for _, subscription := range s.sub {
 		delete(t.subs, subscription)
+		if d, ok := t.durables[subscription.durable]; ok && d.sub == subscription {
+			d.sub = nil
+			d.selector = subscription.selector
+			d.since = now
+		}
 	}
 	t.Unlock()
 	return nil
 }
 
+// remove deletes the durable subscription with the given key
+// and returns true if the durable subscription existed.
+func (t *topic) remove(key string) bool {
+	t.Lock()
+	d, ok := t.durables[key]
+	if ok {
+		delete(t.durables, key)
+		d.release()
+	}
+	t.Unlock()
+	return ok
+}
+
 func (t *topic) process() error {
 	return nil
 }
@@ -119,12 +276,13 @@ func (t *topic) restore(m *stomp.Message) error {
 	return nil
 }
 
-// returns true if the topic has zero subscribers indicating
-// that it can be recycled.
+// returns true if the topic has zero subscribers, retained messages
+// and durable subscriptions indicating that it can be recycled.
 func (t *topic) recycle() (ok bool) {
-	t.RLock()
-	ok = len(t.subs) == 0 && len(t.hist) == 0
-	t.RUnlock()
+	t.Lock()
+	t.prune(time.Now())
+	ok = len(t.subs) == 0 && len(t.hist) == 0 && len(t.durables) == 0
+	t.Unlock()
 	return
 }
 
@@ -132,3 +290,22 @@ func (t *topic) recycle() (ok bool) {
 func (t *topic) destination() string {
 	return string(t.dest)
 }
+
+// returns the number of messages buffered for
+// offline durable subscribers.
+func (t *topic) pending() (n int) {
+	t.RLock()
+	for _, d := range t.durables {
+		n += d.pending()
+	}
+	t.RUnlock()
+	return
+}
+
+// returns the number of subscribers.
+func (t *topic) subscribers() (n int) {
+	t.RLock()
+	n = len(t.subs)
+	t.RUnlock()
+	return
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/drone/mq/stomp"
 )
@@ -54,14 +55,14 @@ func Test_topic_publish_retain(t *testing.T) {
 
 	b := newTopic(m.Dest)
 	b.publish(m)
-	if len(b.hist) != 1 || !bytes.Equal(b.hist[0].Body, m.Body) {
+	if len(b.hist) != 1 || !bytes.Equal(b.hist[0].msg.Body, m.Body) {
 		t.Errorf("expected topic retained message")
 	}
 
 	m.Retain = stomp.RetainLast
 	m.Body = []byte("hello2")
 	b.publish(m)
-	if len(b.hist) != 1 || !bytes.Equal(b.hist[0].Body, m.Body) {
+	if len(b.hist) != 1 || !bytes.Equal(b.hist[0].msg.Body, m.Body) {
 		t.Errorf("expected topic retained message to update")
 	}
 
@@ -156,7 +157,7 @@ func Test_topic_recycle(t *testing.T) {
 	msg := stomp.NewMessage()
 	defer msg.Release()
 
-	brok.hist = []*stomp.Message{msg}
+	brok.hist = []retained{{msg: msg, at: time.Now()}}
 	if brok.recycle() {
 		t.Errorf("want recycle false when no subscribers but retained message")
 	}
@@ -174,3 +175,119 @@ func Test_topic_dest(t *testing.T) {
 		t.Errorf("want destingation name /topic/test got %s", got)
 	}
 }
+
+func Test_topic_publish_retainCount(t *testing.T) {
+	b := newTopic([]byte("/topic/test"))
+	b.maxCount = 3
+
+	for _, body := range []string{"1", "2", "3", "4", "5"} {
+		m := stomp.NewMessage()
+		m.Body = []byte(body)
+		m.Retain = []byte("2")
+		b.publish(m)
+	}
+	if len(b.hist) != 2 || string(b.hist[0].msg.Body) != "4" {
+		t.Errorf("expected topic retains the last 2 messages")
+	}
+
+	// the retained message count is bounded by
+	// the topic maximum.
+	for _, body := range []string{"6", "7", "8", "9"} {
+		m := stomp.NewMessage()
+		m.Body = []byte(body)
+		m.Retain = stomp.RetainAll
+		b.publish(m)
+	}
+	if len(b.hist) != 3 || string(b.hist[0].msg.Body) != "7" {
+		t.Errorf("expected topic retains at most 3 messages")
+	}
+}
+
+func Test_topic_publish_retainAge(t *testing.T) {
+	b := newTopic([]byte("/topic/test"))
+	b.maxAge = time.Minute
+
+	old := stomp.NewMessage()
+	old.Body = []byte("old")
+	b.hist = []retained{{msg: old, at: time.Now().Add(-time.Hour)}}
+
+	expired := stomp.NewMessage()
+	expired.Body = []byte("expired")
+	expired.Expires = []byte("1")
+	expired.Retain = stomp.RetainAll
+	b.publish(expired)
+
+	m := stomp.NewMessage()
+	m.Body = []byte("hello")
+	m.Retain = stomp.RetainAll
+	b.publish(m)
+
+	if len(b.hist) != 1 || string(b.hist[0].msg.Body) != "hello" {
+		t.Errorf("expected topic prunes expired and aged messages")
+	}
+
+	b.hist[0].at = time.Now().Add(-time.Hour)
+	if !b.recycle() {
+		t.Errorf("expected topic recycled when retained messages are aged")
+	}
+}
+
+func Test_topic_durable(t *testing.T) {
+	m := stomp.NewMessage()
+	m.Dest = []byte("/topic/test")
+	m.ID = []byte("1")
+	m.Body = []byte("hello")
+	defer m.Release()
+
+	peer, client := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = peer
+	defer sess.release()
+
+	s := sess.subs(m)
+	s.durable = "dashboard:test"
+	b := newTopic(m.Dest)
+	b.subscribe(s, m)
+
+	// a second subscription with the same key takes over
+	// the durable subscription.
+	other := requestSession()
+	other.peer = peer
+	defer other.release()
+	o := other.subs(m)
+	o.durable = "dashboard:test"
+	b.subscribe(o, m)
+	if len(b.subs) != 1 || b.durables[o.durable].sub != o {
+		t.Errorf("Want durable subscription taken over")
+	}
+
+	// disconnecting the replaced session has no effect.
+	b.disconnect(sess)
+	if b.durables[o.durable].sub != o {
+		t.Errorf("Want durable subscription active")
+	}
+
+	b.disconnect(other)
+	b.publish(m)
+	select {
+	case <-client.Receive():
+		t.Errorf("Want message buffered for offline durable subscription")
+	default:
+	}
+	if b.pending() != 1 {
+		t.Errorf("Want 1 buffered message, got %d", b.pending())
+	}
+	if b.recycle() {
+		t.Errorf("Want topic with durable subscription not recycled")
+	}
+
+	b.unsubscribe(o, m)
+	if len(b.durables) != 1 {
+		t.Errorf("Want inactive durable subscription retained on unsubscribe")
+	}
+	b.subscribe(o, m)
+	b.unsubscribe(o, m)
+	if len(b.durables) != 0 || !b.recycle() {
+		t.Errorf("Want durable subscription deleted on unsubscribe")
+	}
+}
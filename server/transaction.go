@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/drone/mq/logger"
+	"github.com/drone/mq/stomp"
+)
+
+var (
+	errNoTransaction     = errors.New("stomp: no such transaction")
+	errTransactionExists = errors.New("stomp: transaction already exists")
+)
+
+// begin starts a new transaction for the session.
+func (r *router) begin(sess *session, m *stomp.Message) error {
+	if len(m.Tx) == 0 {
+		return errNoTransaction
+	}
+	if _, ok := sess.tx[string(m.Tx)]; ok {
+		return errTransactionExists
+	}
+	sess.tx[string(m.Tx)] = nil
+	return nil
+}
+
+// enlist adds the message to the transaction. The message is applied
+// when the transaction is committed, or discarded when the transaction
+// is aborted.
+func (r *router) enlist(sess *session, m *stomp.Message) error {
+	tx, ok := sess.tx[string(m.Tx)]
+	if !ok {
+		return errNoTransaction
+	}
+
+	// the session must be authorized to publish to the
+	// destination when the message is enlisted.
+	if bytes.Equal(m.Method, stomp.MethodSend) && r.policy != nil {
+		err := r.policy.Write(sess.login(), string(m.Dest))
+		if err != nil {
+			return err
+		}
+	}
+
+	c := m.Copy()
+	c.Tx = nil
+	c.Receipt = nil
+	sess.tx[string(m.Tx)] = append(tx, c)
+	return nil
+}
+
+// commit applies all messages in the transaction, in the order in
+// which they were received. The transaction is validated before any
+// message is applied. If a message cannot be published the remaining
+// messages are discarded and the error reports the applied frames.
+func (r *router) commit(sess *session, m *stomp.Message) error {
+	tx, ok := sess.tx[string(m.Tx)]
+	if !ok {
+		return errNoTransaction
+	}
+	delete(sess.tx, string(m.Tx))
+
+	defer func() {
+		for _, m := range tx {
+			m.Release()
+		}
+	}()
+
+	if err := r.validate(tx); err != nil {
+		return err
+	}
+
+	for i, m := range tx {
+		switch {
+		case bytes.Equal(m.Method, stomp.MethodSend):
+			dest := string(m.Dest)
+			if err := r.publish(m); err != nil {
+				return fmt.Errorf("stomp: transaction partially applied, %d of %d frames applied: %s", i, len(tx), err)
+			}
+			r.published(dest)
+		case bytes.Equal(m.Method, stomp.MethodAck):
+			r.ack(sess, m)
+		case bytes.Equal(m.Method, stomp.MethodNack):
+			r.nack(sess, m)
+		}
+	}
+	return nil
+}
+
+// validate returns an error if a message in the transaction cannot be
+// published. Queues that reject messages when full must have capacity
+// for all messages sent to the queue in the transaction.
+func (r *router) validate(tx []*stomp.Message) error {
+	type usage struct {
+		count int
+		size  int
+	}
+
+	queues := map[string]*usage{}
+	for _, m := range tx {
+		if !bytes.Equal(m.Method, stomp.MethodSend) {
+			continue
+		}
+		if isWildcard(m.Dest) {
+			return errWildcard
+		}
+		if bytes.HasPrefix(m.Dest, routeTopic) {
+			continue
+		}
+		u, ok := queues[string(m.Dest)]
+		if !ok {
+			u = new(usage)
+			queues[string(m.Dest)] = u
+		}
+		u.count++
+		u.size += len(m.Body)
+	}
+
+	for dest, u := range queues {
+		r.RLock()
+		h := r.destinations[dest]
+		r.RUnlock()
+
+		var length, size int
+		limit := r.limit(dest)
+		if q, ok := h.(*queue); ok {
+			q.RLock()
+			length = q.len() + len(q.sched)
+			size = q.bytes
+			limit = q.limit
+			q.RUnlock()
+		}
+
+		// messages sent to a full queue are only rejected if the
+		// queue does not drop or dead-letter messages.
+		switch {
+		case limit.Overflow == OverflowDropOldest:
+			continue
+		case limit.Overflow == OverflowDeadLetter && !strings.HasPrefix(dest, string(routeDLQ)):
+			continue
+		}
+		if limit.MaxLength != 0 && length+u.count > limit.MaxLength ||
+			limit.MaxBytes != 0 && size+u.size > limit.MaxBytes {
+			logger.Noticef("stomp: reject transaction: %s: %s", dest, errQueueFull)
+			return errQueueFull
+		}
+	}
+	return nil
+}
+
+// abort discards all messages in the transaction.
+func (r *router) abort(sess *session, m *stomp.Message) error {
+	tx, ok := sess.tx[string(m.Tx)]
+	if !ok {
+		return errNoTransaction
+	}
+	delete(sess.tx, string(m.Tx))
+
+	for _, m := range tx {
+		m.Release()
+	}
+	return nil
+}
+
+// transactional returns true if the message is sent as part of
+// a transaction.
+func transactional(m *stomp.Message) bool {
+	return len(m.Tx) != 0 && (bytes.Equal(m.Method, stomp.MethodSend) ||
+		bytes.Equal(m.Method, stomp.MethodAck) ||
+		bytes.Equal(m.Method, stomp.MethodNack))
+}
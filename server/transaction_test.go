@@ -0,0 +1,125 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/drone/mq/stomp"
+)
+
+func TestTransaction(t *testing.T) {
+	s := NewServer()
+	client := s.Client()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tx, err := client.Begin(stomp.WithReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Send("/queue/test", []byte("hello"), stomp.WithReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.router.destinations["/queue/test"]; ok {
+		t.Errorf("Expect message not published until the transaction is committed")
+	}
+
+	if err := tx.Commit(stomp.WithReceipt()); err != nil {
+		t.Fatal(err)
+	}
+	h, ok := s.router.destinations["/queue/test"]
+	if !ok || h.pending() != 1 {
+		t.Errorf("Expect message published when the transaction is committed")
+	}
+}
+
+func TestTransactionQueueFull(t *testing.T) {
+	s := NewServer(
+		WithQueueLimit(QueueLimit{MaxLength: 2}),
+	)
+	client := s.Client()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	if err := client.Send("/queue/test", []byte("hello"), stomp.WithReceipt()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction is rejected, and no message is published,
+	// if the queue cannot accept every message.
+	tx, err := client.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Send("/queue/other", []byte("hello"))
+	tx.Send("/queue/test", []byte("hello"))
+	tx.Send("/queue/test", []byte("hello"))
+	err = tx.Commit(stomp.WithReceipt())
+	if err == nil || err.Error() != errQueueFull.Error() {
+		t.Errorf("Expect error committing to a full queue, got %v", err)
+	}
+	if h := s.router.destinations["/queue/test"]; h.pending() != 1 {
+		t.Errorf("Expect no message published when the transaction is rejected")
+	}
+	if _, ok := s.router.destinations["/queue/other"]; ok {
+		t.Errorf("Expect no message published when the transaction is rejected")
+	}
+}
+
+func TestTransactionAbort(t *testing.T) {
+	s := NewServer()
+	client := s.Client()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tx, err := client.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Send("/queue/test", []byte("hello"))
+	if err := tx.Abort(stomp.WithReceipt()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.router.destinations["/queue/test"]; ok {
+		t.Errorf("Expect message discarded when the transaction is aborted")
+	}
+
+	err = tx.Commit(stomp.WithReceipt())
+	if err == nil || err.Error() != errNoTransaction.Error() {
+		t.Errorf("Expect error committing an aborted transaction, got %v", err)
+	}
+}
+
+func TestTransactionDisconnect(t *testing.T) {
+	_, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	begin := stomp.NewMessage()
+	begin.Method = stomp.MethodBegin
+	begin.Tx = []byte("tx1")
+
+	send := stomp.NewMessage()
+	send.Method = stomp.MethodSend
+	send.Dest = []byte("/queue/test")
+	send.Tx = []byte("tx1")
+
+	router := newRouter()
+	router.begin(sess, begin)
+	router.enlist(sess, send)
+	router.disconnect(sess)
+
+	if len(sess.tx) != 0 {
+		t.Errorf("Expect transaction aborted on disconnect")
+	}
+	if len(router.destinations) != 0 {
+		t.Errorf("Expect transaction messages discarded on disconnect")
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+)
+
+// wildcard segments used in topic subscriptions. The single segment
+// wildcard matches exactly one segment of the destination, and the
+// multi segment wildcards match one or more trailing segments.
+const (
+	wildcardOne  = "*"
+	wildcardMany = ">"
+	wildcardHash = "#"
+)
+
+// trie is a prefix tree of wildcard topic subscriptions, keyed by the
+// dot-separated segments of the destination name.
+type trie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	topic    *topic
+}
+
+func newTrie() *trie {
+	return &trie{root: newTrieNode()}
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[string]*trieNode)}
+}
+
+// insert adds the wildcard topic to the tree.
+func (t *trie) insert(dest []byte, topic *topic) {
+	n := t.root
+	for _, seg := range segments(dest) {
+		c, ok := n.children[seg]
+		if !ok {
+			c = newTrieNode()
+			n.children[seg] = c
+		}
+		n = c
+	}
+	n.topic = topic
+}
+
+// remove removes the wildcard topic from the tree, pruning
+// branches that are no longer used.
+func (t *trie) remove(dest []byte) {
+	t.root.remove(segments(dest))
+}
+
+func (n *trieNode) remove(segs []string) bool {
+	if len(segs) == 0 {
+		n.topic = nil
+	} else if c, ok := n.children[segs[0]]; ok {
+		if c.remove(segs[1:]) {
+			delete(n.children, segs[0])
+		}
+	}
+	return n.topic == nil && len(n.children) == 0
+}
+
+// match returns the wildcard topics matching the destination.
+func (t *trie) match(dest []byte) []*topic {
+	var topics []*topic
+	t.root.match(segments(dest), &topics)
+	return topics
+}
+
+func (n *trieNode) match(segs []string, topics *[]*topic) {
+	if len(segs) == 0 {
+		if n.topic != nil {
+			*topics = append(*topics, n.topic)
+		}
+		return
+	}
+	if c, ok := n.children[segs[0]]; ok {
+		c.match(segs[1:], topics)
+	}
+	if c, ok := n.children[wildcardOne]; ok {
+		c.match(segs[1:], topics)
+	}
+	if c, ok := n.children[wildcardMany]; ok && c.topic != nil {
+		*topics = append(*topics, c.topic)
+	}
+	if c, ok := n.children[wildcardHash]; ok && c.topic != nil {
+		*topics = append(*topics, c.topic)
+	}
+}
+
+// segments returns the dot-separated segments of the topic name.
+func segments(dest []byte) []string {
+	name := bytes.TrimPrefix(dest, routeTopic)
+	return strings.Split(string(name), ".")
+}
+
+// isWildcard returns true if the destination is a topic that includes
+// one or more wildcard segments.
+func isWildcard(dest []byte) bool {
+	if !bytes.HasPrefix(dest, routeTopic) {
+		return false
+	}
+	for _, seg := range segments(dest) {
+		switch seg {
+		case wildcardOne, wildcardMany, wildcardHash:
+			return true
+		}
+	}
+	return false
+}
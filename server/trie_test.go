@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/drone/mq/stomp"
+)
+
+func Test_trie_match(t *testing.T) {
+	var (
+		one  = newTopic([]byte("/topic/builds.*"))
+		many = newTopic([]byte("/topic/builds.>"))
+		hash = newTopic([]byte("/topic/#"))
+		deep = newTopic([]byte("/topic/builds.*.failed"))
+	)
+	tree := newTrie()
+	tree.insert(one.dest, one)
+	tree.insert(many.dest, many)
+	tree.insert(hash.dest, hash)
+	tree.insert(deep.dest, deep)
+
+	var tests = []struct {
+		dest   string
+		topics []*topic
+	}{
+		{"/topic/builds.linux", []*topic{one, many, hash}},
+		{"/topic/builds.linux.failed", []*topic{deep, many, hash}},
+		{"/topic/builds.linux.passed", []*topic{many, hash}},
+		{"/topic/builds", []*topic{hash}},
+		{"/topic/deploys.linux", []*topic{hash}},
+	}
+	for _, test := range tests {
+		got := tree.match([]byte(test.dest))
+		if len(got) != len(test.topics) {
+			t.Errorf("Want %d matches for %s, got %d", len(test.topics), test.dest, len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.topics[i] {
+				t.Errorf("Want match %s for %s, got %s", test.topics[i].dest, test.dest, got[i].dest)
+			}
+		}
+	}
+
+	tree.remove(one.dest)
+	tree.remove(many.dest)
+	tree.remove(deep.dest)
+	if got := tree.match([]byte("/topic/builds.linux")); len(got) != 1 {
+		t.Errorf("Want wildcard topics removed from the tree")
+	}
+	if _, ok := tree.root.children["builds"]; ok {
+		t.Errorf("Want unused branches pruned from the tree")
+	}
+}
+
+func Test_isWildcard(t *testing.T) {
+	var tests = []struct {
+		dest string
+		want bool
+	}{
+		{"/topic/builds", false},
+		{"/topic/builds.linux", false},
+		{"/topic/builds.*", true},
+		{"/topic/builds.>", true},
+		{"/topic/#", true},
+		{"/queue/builds.*", false},
+	}
+	for _, test := range tests {
+		if got := isWildcard([]byte(test.dest)); got != test.want {
+			t.Errorf("Want isWildcard %s %v, got %v", test.dest, test.want, got)
+		}
+	}
+}
+
+func TestWildcardSubscribe(t *testing.T) {
+	client, server := stomp.Pipe()
+	sess := requestSession()
+	sess.peer = server
+
+	sub := stomp.NewMessage()
+	sub.ID = []byte("1")
+	sub.Dest = []byte("/topic/builds.*")
+
+	router := newRouter()
+	router.subscribe(sess, sub)
+
+	// the topic is created after the wildcard subscription.
+	msg := stomp.NewMessage()
+	msg.Method = stomp.MethodSend
+	msg.Dest = []byte("/topic/builds.linux")
+	msg.Body = []byte("hello")
+	router.publish(msg)
+
+	select {
+	case got := <-client.Receive():
+		if !bytes.Equal(got.Body, msg.Body) {
+			t.Errorf("Expect message delivered to wildcard subscription")
+		}
+		if !bytes.Equal(got.Dest, msg.Dest) {
+			t.Errorf("Expect message delivered with the published destination")
+		}
+	default:
+		t.Errorf("Expect message delivered to wildcard subscription")
+	}
+
+	if err := router.publish(sub); err != errWildcard {
+		t.Errorf("Expect error publishing to a wildcard destination")
+	}
+
+	unsub := stomp.NewMessage()
+	unsub.ID = []byte("1")
+	router.unsubscribe(sess, unsub)
+	if got := router.wildcards.match(msg.Dest); len(got) != 0 {
+		t.Errorf("Expect wildcard subscription removed when recycled")
+	}
+}
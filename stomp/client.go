@@ -12,6 +12,8 @@ import (
 
 	"github.com/drone/mq/logger"
 	"github.com/drone/mq/stomp/dialer"
+
+	"golang.org/x/net/context"
 )
 
 // Client defines a client connection to a STOMP server.
@@ -19,12 +21,35 @@ type Client struct {
 	mu sync.Mutex
 
 	peer Peer
-	subs map[string]Handler
-	wait map[string]chan struct{}
+	subs map[string]*subscriber
+	wait map[string]chan error
 	done chan error
+	quit chan struct{}
+
+	// err is the error received from the server, which
+	// closes the connection after sending the error.
+	err error
 
 	seq int64
 
+	// request reply settings. The reply queue is created
+	// when the first request is sent, and calls maps the
+	// pending requests by correlation id.
+	replyMu sync.Mutex
+	replyTo string
+	calls   map[string]chan *Message
+
+	// reconnect settings. The target is only set when the
+	// client is configured to automatically reconnect.
+	target  string
+	opts    []MessageOption
+	backoff time.Duration
+	maxWait time.Duration
+	buffer  int
+	pending []*Message
+	offline bool
+	closed  bool
+
 	skipVerify      bool
 	readBufferSize  int
 	writeBufferSize int
@@ -32,32 +57,48 @@ type Client struct {
 }
 
 // New returns a new STOMP client using the given connection.
-func New(peer Peer) *Client {
-	return &Client{
-		peer: peer,
-		subs: make(map[string]Handler),
-		wait: make(map[string]chan struct{}),
-		done: make(chan error, 1),
+func New(peer Peer, opts ...ClientOption) *Client {
+	c := &Client{
+		peer:  peer,
+		subs:  make(map[string]*subscriber),
+		wait:  make(map[string]chan error),
+		calls: make(map[string]chan *Message),
+		done:  make(chan error, 1),
+		quit:  make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Dial creates a client connection to the given target.
-func Dial(target string) (*Client, error) {
+func Dial(target string, opts ...ClientOption) (*Client, error) {
 	conn, err := dialer.Dial(target)
 	if err != nil {
 		return nil, err
 	}
-	return New(Conn(conn)), nil
+	return New(Conn(conn), opts...), nil
 }
 
-// Send sends the data to the given destination.
+// Send sends the data to the given destination. Unless a receipt is
+// requested, Send does not wait for the server to process the message,
+// and an error sent by the server is returned by the Done channel.
 func (c *Client) Send(dest string, data []byte, opts ...MessageOption) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.SendContext(ctx, dest, data, opts...)
+}
+
+// SendContext sends the data to the given destination. If a receipt is
+// requested the context bounds the time spent waiting for the receipt.
+func (c *Client) SendContext(ctx context.Context, dest string, data []byte, opts ...MessageOption) error {
 	m := NewMessage()
 	m.Method = MethodSend
 	m.Dest = []byte(dest)
 	m.Body = data
 	m.Apply(opts...)
-	return c.sendMessage(m)
+	return c.sendMessageContext(ctx, m)
 }
 
 // SendJSON sends the JSON encoding of v to the given destination.
@@ -73,7 +114,15 @@ func (c *Client) SendJSON(dest string, v interface{}, opts ...MessageOption) err
 }
 
 // Subscribe subscribes to the given destination.
-func (c *Client) Subscribe(dest string, handler Handler, opts ...MessageOption) (id []byte, err error) {
+func (c *Client) Subscribe(dest string, handler Handler, opts ...MessageOption) ([]byte, error) {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.SubscribeContext(ctx, dest, handler, opts...)
+}
+
+// SubscribeContext subscribes to the given destination. If a receipt is
+// requested the context bounds the time spent waiting for the receipt.
+func (c *Client) SubscribeContext(ctx context.Context, dest string, handler Handler, opts ...MessageOption) (id []byte, err error) {
 	id = c.incr()
 
 	m := NewMessage()
@@ -82,23 +131,77 @@ func (c *Client) Subscribe(dest string, handler Handler, opts ...MessageOption)
 	m.Dest = []byte(dest)
 	m.Apply(opts...)
 
+	// the handler is invoked by a worker pool if the
+	// subscription is configured with concurrency.
+	var workers *workerPool
+	if n := m.Header.GetInt(string(headerConcurrency)); n > 0 {
+		workers = newWorkerPool(n, handler)
+	}
+	m.Header.Del(headerConcurrency)
+
 	c.mu.Lock()
-	c.subs[string(id)] = handler
+	c.subs[string(id)] = &subscriber{
+		dest:    dest,
+		handler: handler,
+		opts:    opts,
+		pool:    workers,
+	}
 	c.mu.Unlock()
 
-	err = c.sendMessage(m)
+	err = c.sendMessageContext(ctx, m)
 	if err != nil {
 		c.mu.Lock()
 		delete(c.subs, string(id))
 		c.mu.Unlock()
+		if workers != nil {
+			workers.close(ctx)
+		}
 		return
 	}
 	return
 }
 
+// SubscribeJSON subscribes to the given destination and decodes the
+// JSON-encoded body of each message into a new value passed to fn, which
+// must be a func(context.Context, *T) error. If the subscription requires
+// acknowledgement the message is acknowledged when fn returns nil, and
+// negative-acknowledged if the body cannot be decoded, or fn returns an
+// error or panics. Cumulative acknowledgement cannot be combined with
+// concurrency, since acknowledging a message would acknowledge the
+// messages still handled by other workers.
+func (c *Client) SubscribeJSON(dest string, fn interface{}, opts ...MessageOption) ([]byte, error) {
+	handler, err := newJSONHandler(c, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMessage()
+	m.Apply(opts...)
+	cumulative := bytes.Equal(m.Ack, AckClient) &&
+		m.Header.GetInt(string(headerConcurrency)) > 1
+	m.Release()
+	if cumulative {
+		return nil, errJSONCumulative
+	}
+	return c.Subscribe(dest, handler, opts...)
+}
+
 // Unsubscribe unsubscribes to the destination.
 func (c *Client) Unsubscribe(id []byte, opts ...MessageOption) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.UnsubscribeContext(ctx, id, opts...)
+}
+
+// UnsubscribeContext unsubscribes to the destination. If a receipt is
+// requested the context bounds the time spent waiting for the receipt.
+// If the subscription uses a worker pool, UnsubscribeContext waits for
+// the workers to handle the queued messages, bounded by the context. If
+// invoked by the subscription handler, it returns when the context is
+// done, since the calling worker cannot complete until it returns.
+func (c *Client) UnsubscribeContext(ctx context.Context, id []byte, opts ...MessageOption) error {
 	c.mu.Lock()
+	sub, ok := c.subs[string(id)]
 	delete(c.subs, string(id))
 	c.mu.Unlock()
 
@@ -107,14 +210,38 @@ func (c *Client) Unsubscribe(id []byte, opts ...MessageOption) error {
 	m.ID = id
 	m.Apply(opts...)
 
-	return c.sendMessage(m)
+	err := c.sendMessageContext(ctx, m)
+	if ok && sub.pool != nil {
+		sub.pool.close(ctx)
+	}
+	return err
 }
 
 // Ack acknowledges the messages with the given id.
 func (c *Client) Ack(id []byte, opts ...MessageOption) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.AckContext(ctx, id, opts...)
+}
+
+// AckContext acknowledges the messages with the given id. If a receipt is
+// requested the context bounds the time spent waiting for the receipt.
+func (c *Client) AckContext(ctx context.Context, id []byte, opts ...MessageOption) error {
+	m := NewMessage()
+	m.Method = MethodAck
+	m.ID = id
+	m.Apply(opts...)
+
+	return c.sendMessageContext(ctx, m)
+}
+
+// Extend resets the ack timeout of the message with the given id,
+// preventing redelivery while the message is still being processed.
+func (c *Client) Extend(id []byte, opts ...MessageOption) error {
 	m := NewMessage()
 	m.Method = MethodAck
 	m.ID = id
+	m.Header.Set(HeaderExtend, []byte("true"))
 	m.Apply(opts...)
 
 	return c.sendMessage(m)
@@ -122,50 +249,136 @@ func (c *Client) Ack(id []byte, opts ...MessageOption) error {
 
 // Nack negative-acknowledges the messages with the given id.
 func (c *Client) Nack(id []byte, opts ...MessageOption) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.NackContext(ctx, id, opts...)
+}
+
+// NackContext negative-acknowledges the messages with the given id. If a
+// receipt is requested the context bounds the time spent waiting for the
+// receipt.
+func (c *Client) NackContext(ctx context.Context, id []byte, opts ...MessageOption) error {
 	m := NewMessage()
 	m.Method = MethodNack
 	m.ID = id
 	m.Apply(opts...)
 
-	return c.peer.Send(m)
+	return c.sendMessageContext(ctx, m)
 }
 
 // Connect opens the connection and establishes the session.
 func (c *Client) Connect(opts ...MessageOption) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.ConnectContext(ctx, opts...)
+}
+
+// ConnectContext opens the connection and establishes the session. If the
+// context is canceled or times out before the server accepts the session
+// the connection is closed.
+func (c *Client) ConnectContext(ctx context.Context, opts ...MessageOption) error {
+	c.mu.Lock()
+	c.opts = opts
+	c.mu.Unlock()
+
 	m := NewMessage()
 	m.Proto = STOMP
 	m.Method = MethodStomp
+	m.Heartbeat = FormatHeartbeat(heartbeatTime, heartbeatTime)
 	m.Apply(opts...)
-	if err := c.sendMessage(m); err != nil {
+	beat := m.Heartbeat
+	if err := c.send(m); err != nil {
 		return err
 	}
 
-	m, ok := <-c.peer.Receive()
+	var ok bool
+	select {
+	case <-ctx.Done():
+		c.peer.Close()
+		return ctx.Err()
+	case m, ok = <-c.peer.Receive():
+	}
 	if !ok {
 		return io.EOF
 	}
 	defer m.Release()
 
+	if bytes.Equal(m.Method, MethodError) {
+		return newError(m)
+	}
 	if !bytes.Equal(m.Method, MethodConnected) {
 		return fmt.Errorf("stomp: inbound message: unexpected method, want connected")
 	}
+	heartbeat(c.peer, beat, m.Heartbeat)
 	go c.listen()
 	return nil
 }
 
-// Disconnect terminates the session and closes the connection.
+// Disconnect terminates the session and closes the connection. Messages
+// queued for subscription worker pools are handled before the session is
+// terminated, bounded by the client timeout.
 func (c *Client) Disconnect() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return io.EOF
+	}
+	c.closed = true
+	close(c.quit)
+	offline := c.offline
+	var pools []*workerPool
+	for _, sub := range c.subs {
+		if sub.pool != nil {
+			pools = append(pools, sub.pool)
+		}
+	}
+	c.mu.Unlock()
+
+	// the worker pools handle the queued messages before the
+	// session is terminated, allowing handlers to acknowledge
+	// the messages.
+	ctx, cancel := c.withTimeout()
+	for _, p := range pools {
+		p.close(ctx)
+	}
+	cancel()
+
+	// if the client is reconnecting there is no connection
+	// to terminate, and buffered messages are discarded.
+	if offline {
+		c.mu.Lock()
+		for _, m := range c.pending {
+			if receiptc, ok := c.wait[string(m.Receipt)]; ok {
+				receiptc <- ErrDisconnected
+			}
+			m.Release()
+		}
+		c.pending = nil
+		c.mu.Unlock()
+		return nil
+	}
+
 	m := NewMessage()
 	m.Method = MethodDisconnect
 	c.sendMessage(m)
 	return c.peer.Close()
 }
 
-// Done returns a channel
+// Done returns a channel that receives the error that terminated the
+// session. This is the error sent by the server, if any, or io.EOF if
+// the connection is closed.
 func (c *Client) Done() <-chan error {
 	return c.done
 }
 
+// heartbeat configures the peer to send and monitor heart-beats using
+// the intervals negotiated with the server.
+func heartbeat(peer Peer, local, remote []byte) {
+	if h, ok := peer.(Heartbeater); ok {
+		h.Heartbeat(NegotiateHeartbeat(local, remote))
+	}
+}
+
 func (c *Client) incr() []byte {
 	c.mu.Lock()
 	i := c.seq
@@ -190,9 +403,22 @@ func (c *Client) listen() {
 	}()
 
 	for {
-		m, ok := <-c.peer.Receive()
+		c.mu.Lock()
+		peer := c.peer
+		c.mu.Unlock()
+
+		m, ok := <-peer.Receive()
 		if !ok {
-			c.done <- io.EOF
+			if c.target != "" && c.reconnect() {
+				continue
+			}
+			c.mu.Lock()
+			err := c.err
+			c.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			c.done <- err
 			return
 		}
 
@@ -201,6 +427,8 @@ func (c *Client) listen() {
 			c.handleMessage(m)
 		case bytes.Equal(m.Method, MethodRecipet):
 			c.handleReceipt(m)
+		case bytes.Equal(m.Method, MethodError):
+			c.handleError(m)
 		default:
 			logger.Noticef("stomp client: unknown message type: %s",
 				string(m.Method),
@@ -219,12 +447,29 @@ func (c *Client) handleReceipt(m *Message) {
 		)
 		return
 	}
-	receiptc <- struct{}{}
+	receiptc <- nil
+}
+
+func (c *Client) handleError(m *Message) {
+	err := newError(m)
+	defer m.Release()
+
+	// the server closes the connection after sending the
+	// error, which is returned by the done channel.
+	c.mu.Lock()
+	c.err = err
+	receiptc, ok := c.wait[string(m.Receipt)]
+	c.mu.Unlock()
+	if !ok || len(m.Receipt) == 0 {
+		logger.Warningf("stomp client: %s", err)
+		return
+	}
+	receiptc <- err
 }
 
 func (c *Client) handleMessage(m *Message) {
 	c.mu.Lock()
-	handler, ok := c.subs[string(m.Subs)]
+	sub, ok := c.subs[string(m.Subs)]
 	c.mu.Unlock()
 	if !ok {
 		logger.Noticef("stomp client: subscription not found: %s",
@@ -232,28 +477,60 @@ func (c *Client) handleMessage(m *Message) {
 		)
 		return
 	}
-	handler.Handle(m)
+	if sub.pool != nil {
+		sub.pool.handle(m)
+		return
+	}
+	sub.handler.Handle(m)
 }
 
+// withTimeout returns a context bounded by the default client
+// timeout. If the timeout is zero the context has no deadline.
+func (c *Client) withTimeout() (context.Context, context.CancelFunc) {
+	if c.timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// sendMessage sends the message, waiting for the receipt if
+// requested, bounded by the default client timeout.
 func (c *Client) sendMessage(m *Message) error {
+	ctx, cancel := c.withTimeout()
+	defer cancel()
+	return c.sendMessageContext(ctx, m)
+}
+
+// sendMessageContext sends the message. If a receipt is requested
+// it waits for the receipt until the context is canceled.
+func (c *Client) sendMessageContext(ctx context.Context, m *Message) error {
 	if len(m.Receipt) == 0 {
-		return c.peer.Send(m)
+		return c.send(m)
 	}
 
-	receiptc := make(chan struct{}, 1)
-	c.wait[string(m.Receipt)] = receiptc
+	// the receipt is copied since the message is released
+	// once it is written to the transport.
+	receipt := string(m.Receipt)
+	receiptc := make(chan error, 1)
+	c.mu.Lock()
+	c.wait[receipt] = receiptc
+	c.mu.Unlock()
 
 	defer func() {
-		delete(c.wait, string(m.Receipt))
+		c.mu.Lock()
+		delete(c.wait, receipt)
+		c.mu.Unlock()
 	}()
 
-	err := c.peer.Send(m)
+	err := c.send(m)
 	if err != nil {
 		return err
 	}
 
 	select {
-	case <-receiptc:
-		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-receiptc:
+		return err
 	}
 }
@@ -0,0 +1,346 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestClientConnectError(t *testing.T) {
+	a, b := Pipe()
+	client := New(a)
+
+	go func() {
+		<-b.Receive()
+
+		m := NewMessage()
+		m.Method = MethodError
+		m.Header.Add(HeaderMessage, []byte("stomp: not authorized"))
+		b.Send(m)
+	}()
+
+	err := client.Connect()
+	if err == nil {
+		t.Fatalf("Want error when server responds with error message")
+	}
+	if _, ok := err.(*Error); !ok {
+		t.Errorf("Want stomp.Error, got %T", err)
+	}
+	if got := err.Error(); got != "stomp: not authorized" {
+		t.Errorf("Want error message from header, got %q", got)
+	}
+}
+
+func TestClientSendError(t *testing.T) {
+	a, b := Pipe()
+	client := New(a)
+
+	go func() {
+		<-b.Receive()
+
+		m := NewMessage()
+		m.Method = MethodConnected
+		b.Send(m)
+
+		in := <-b.Receive()
+		m = NewMessage()
+		m.Method = MethodError
+		m.Receipt = in.Receipt
+		m.Header.Add(HeaderMessage, []byte("stomp: no such destination"))
+		b.Send(m)
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.Send("/queue/test", []byte("hello"), WithReceipt())
+	if err == nil {
+		t.Fatalf("Want error returned to the caller of Send")
+	}
+	if got := err.Error(); got != "stomp: no such destination" {
+		t.Errorf("Want error message from header, got %q", got)
+	}
+}
+
+func TestClientConnectContext(t *testing.T) {
+	a, b := Pipe()
+	client := New(a)
+
+	// the server never accepts the session.
+	go func() {
+		<-b.Receive()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err := client.ConnectContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want connect deadline exceeded, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	a, b := Pipe()
+	client := New(a, WithTimeout(time.Millisecond*50))
+
+	// the server accepts the session but never sends receipts.
+	go func() {
+		<-b.Receive()
+
+		m := NewMessage()
+		m.Method = MethodConnected
+		b.Send(m)
+
+		for range b.Receive() {
+		}
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.Send("/queue/test", []byte("hello"), WithReceipt())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want send deadline exceeded, got %v", err)
+	}
+	_, err = client.Subscribe("/queue/test", HandlerFunc(func(m *Message) {}), WithReceipt())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want subscribe deadline exceeded, got %v", err)
+	}
+	if len(client.wait) != 0 {
+		t.Errorf("Want pending receipts removed after timeout")
+	}
+}
+
+func TestClientConcurrency(t *testing.T) {
+	a, b := Pipe()
+	client := New(a)
+
+	go func() {
+		<-b.Receive()
+
+		m := NewMessage()
+		m.Method = MethodConnected
+		b.Send(m)
+
+		sub := <-b.Receive()
+		if len(sub.Header.Get(headerConcurrency)) != 0 {
+			t.Errorf("Want concurrency header removed from subscribe frame")
+		}
+		for i := 0; i < 2; i++ {
+			m := NewMessage()
+			m.Method = MethodMessage
+			m.Subs = sub.ID
+			b.Send(m)
+		}
+
+		for in := range b.Receive() {
+			if len(in.Receipt) == 0 {
+				continue
+			}
+			m := NewMessage()
+			m.Method = MethodRecipet
+			m.Receipt = in.Receipt
+			b.Send(m)
+		}
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		started = make(chan bool, 2)
+		release = make(chan bool)
+		handled = make(chan bool, 2)
+	)
+	handler := func(m *Message) {
+		started <- true
+		<-release
+		handled <- true
+	}
+	_, err := client.Subscribe("/queue/test", HandlerFunc(handler), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both messages are handled concurrently, and receipts are
+	// processed while the handlers are busy.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("Want messages handled concurrently")
+		}
+	}
+	if err := client.Send("/queue/test", nil, WithReceipt()); err != nil {
+		t.Errorf("Want receipt processed while handlers are busy, got %v", err)
+	}
+
+	// disconnect waits for the handlers to complete.
+	close(release)
+	client.Disconnect()
+	if len(handled) != 2 {
+		t.Errorf("Want handlers complete before disconnect returns")
+	}
+}
+
+func TestClientConcurrencyUnsubscribe(t *testing.T) {
+	defer func(d time.Duration) { drainTimeout = d }(drainTimeout)
+	drainTimeout = time.Millisecond * 50
+
+	// the pool is saturated when the messages exceed the
+	// busy worker and the queue capacity.
+	for _, count := range []int{1, 4} {
+		a, b := Pipe()
+		client := New(a)
+
+		go func() {
+			<-b.Receive()
+
+			m := NewMessage()
+			m.Method = MethodConnected
+			b.Send(m)
+
+			sub := <-b.Receive()
+			for i := 0; i < count; i++ {
+				m = NewMessage()
+				m.Method = MethodMessage
+				m.Subs = sub.ID
+				b.Send(m)
+			}
+
+			for range b.Receive() {
+			}
+		}()
+
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the handler unsubscribes and disconnects, which must
+		// not wait for the calling worker indefinitely.
+		var (
+			id    []byte
+			once  sync.Once
+			ready = make(chan bool)
+			done  = make(chan bool)
+		)
+		handler := func(m *Message) {
+			<-ready
+			once.Do(func() {
+				client.Unsubscribe(id)
+				client.Disconnect()
+				close(done)
+			})
+		}
+		id, err := client.Subscribe("/queue/test", HandlerFunc(handler), WithConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// wait for the messages to saturate the pool
+		// before the handler unsubscribes.
+		time.Sleep(time.Millisecond * 10)
+		close(ready)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("Want handler to unsubscribe and disconnect without deadlock, with %d messages", count)
+		}
+	}
+}
+
+func TestClientSubscribeJSON(t *testing.T) {
+	a, b := Pipe()
+	client := New(a)
+
+	type build struct {
+		Status string `json:"status"`
+	}
+
+	acks := make(chan *Message, 4)
+	go func() {
+		<-b.Receive()
+
+		m := NewMessage()
+		m.Method = MethodConnected
+		b.Send(m)
+
+		sub := <-b.Receive()
+		for i, body := range []string{
+			`{"status":"success"}`,
+			`{"status":`,
+			`{"status":"failure"}`,
+			`{"status":"panic"}`,
+		} {
+			m := NewMessage()
+			m.Method = MethodMessage
+			m.Subs = sub.ID
+			m.Ack = []byte{byte('1' + i)}
+			m.Body = []byte(body)
+			b.Send(m)
+		}
+		for m := range b.Receive() {
+			acks <- m
+		}
+	}()
+
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	fn := func(ctx context.Context, v *build) error {
+		switch v.Status {
+		case "failure":
+			return errors.New("build failed")
+		case "panic":
+			panic("build panic")
+		}
+		return nil
+	}
+	if _, err := client.SubscribeJSON("/queue/test", fn, WithAck("client-individual")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		method []byte
+		id     string
+	}{
+		{MethodAck, "1"},
+		{MethodNack, "2"},
+		{MethodNack, "3"},
+		{MethodNack, "4"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-acks:
+			if !bytes.Equal(got.Method, w.method) || string(got.ID) != w.id {
+				t.Errorf("Want %s %s, got %s %s", w.method, w.id, got.Method, got.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Want %s %s", w.method, w.id)
+		}
+	}
+
+	_, err := client.SubscribeJSON("/queue/test", func(v *build) {})
+	if err != errJSONHandler {
+		t.Errorf("Want error %s, got %v", errJSONHandler, err)
+	}
+
+	_, err = client.SubscribeJSON("/queue/test",
+		func(ctx context.Context, v *build) error { return nil },
+		WithAck("client"),
+		WithConcurrency(2),
+	)
+	if err != errJSONCumulative {
+		t.Errorf("Want error %s, got %v", errJSONCumulative, err)
+	}
+}
@@ -2,24 +2,40 @@ package stomp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/drone/mq/logger"
 )
 
-const (
-	bufferSize  = 32 << 10 // default buffer size 32KB
-	bufferLimit = 32 << 15 // default buffer limit 1MB
+const bufferSize = 32 << 10 // default buffer size 32KB
+
+// maxFrameSize is the hard limit on the size of frames received from the
+// remote peer, which applies even if the frame size limit is removed.
+var maxFrameSize = 64 << 20 // 64MB
+
+var (
+	contentLen    = []byte("content-length:")
+	errTerminator = errors.New("stomp: frame not terminated by a null byte")
+	errFrameSize  = errors.New("stomp: frame size limit exceeded")
+	errHeaders    = errors.New("stomp: header count limit exceeded")
+	errHeaderSize = errors.New("stomp: header length limit exceeded")
 )
 
 var (
 	never    time.Time
 	deadline = time.Second * 5
 
+	// heartbeatTime is the default heart-beat interval.
 	heartbeatTime = time.Second * 30
-	heartbeatWait = time.Second * 60
+
+	// heartbeatWait is the multiple of the negotiated heart-beat interval
+	// to wait for data before the remote peer is considered dead.
+	heartbeatWait = time.Duration(2)
 )
 
 type connPeer struct {
@@ -30,11 +46,23 @@ type connPeer struct {
 	writer   *bufio.Writer
 	incoming chan *Message
 	outgoing chan *Message
+
+	once sync.Once
+	mu   sync.Mutex
+	send time.Duration
+	recv time.Duration
+
+	// limits applied to incoming frames. A zero value
+	// indicates the size is limited only by the hard
+	// limit on the frame size.
+	frameSize  int
+	headers    int
+	headerSize int
 }
 
 // Conn creates a network-connected peer that reads and writes
 // messages using net.Conn c.
-func Conn(c net.Conn) Peer {
+func Conn(c net.Conn, opts ...ConnOption) Peer {
 	p := &connPeer{
 		reader:   bufio.NewReaderSize(c, bufferSize),
 		writer:   bufio.NewWriterSize(c, bufferSize),
@@ -43,6 +71,9 @@ func Conn(c net.Conn) Peer {
 		done:     make(chan bool),
 		conn:     c,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
 
 	go p.readInto(p.incoming)
 	go p.writeFrom(p.outgoing)
@@ -57,12 +88,39 @@ func (c *connPeer) Send(message *Message) error {
 	select {
 	case <-c.done:
 		return io.EOF
-	default:
-		c.outgoing <- message
+	case c.outgoing <- message:
 		return nil
 	}
 }
 
+// Heartbeat configures the heart-beat send and receive intervals.
+func (c *connPeer) Heartbeat(send, recv time.Duration) {
+	c.mu.Lock()
+	c.send = send
+	c.recv = recv
+	c.mu.Unlock()
+	c.extend()
+}
+
+func (c *connPeer) heartbeat() (send, recv time.Duration) {
+	c.mu.Lock()
+	send = c.send
+	recv = c.recv
+	c.mu.Unlock()
+	return
+}
+
+// extend extends the read deadline using the heart-beat receive
+// interval. If heart-beating is disabled the deadline is removed.
+func (c *connPeer) extend() {
+	_, recv := c.heartbeat()
+	if recv == 0 {
+		c.conn.SetReadDeadline(never)
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(recv * heartbeatWait))
+	}
+}
+
 func (c *connPeer) Addr() string {
 	return c.conn.RemoteAddr().String()
 }
@@ -71,72 +129,196 @@ func (c *connPeer) Close() error {
 	return c.close()
 }
 
+// close signals the reader and writer to stop. The outgoing channel
+// is never closed so that close is safe to call while sending. The
+// write deadline unblocks a writer waiting on an unresponsive peer.
 func (c *connPeer) close() error {
-	select {
-	case <-c.done:
-		return io.EOF
-	default:
+	err := io.EOF
+	c.once.Do(func() {
 		close(c.done)
-		close(c.incoming)
-		close(c.outgoing)
-		return nil
-	}
+		c.conn.SetWriteDeadline(time.Now().Add(deadline))
+		err = nil
+	})
+	return err
 }
 
 func (c *connPeer) readInto(messages chan<- *Message) {
+	defer close(messages)
 	defer c.close()
 
 	for {
-		// lim := io.LimitReader(c.conn, bufferLimit)
-		// buf := bufio.NewReaderSize(lim, bufferSize)
+		c.extend()
 
-		buf, err := c.reader.ReadBytes(0)
+		// end-of-line characters received between frames
+		// are heart-beats.
+		b, err := c.reader.ReadByte()
 		if err != nil {
 			break
 		}
-		if len(buf) == 1 {
-			c.conn.SetReadDeadline(time.Now().Add(heartbeatWait))
+		if b == '\n' || b == '\r' || b == 0 {
 			logger.Verbosef("stomp: received heart-beat")
 			continue
 		}
+		c.reader.UnreadByte()
 
+		buf, err := c.readFrame()
+		switch err {
+		case nil:
+		case errFrameSize, errHeaders, errHeaderSize, errContentLength:
+			logger.Warningf("stomp: cannot read frame. %s", err)
+			c.error(err)
+			return
+		default:
+			return
+		}
+
+		// a malformed frame is reported to the remote
+		// peer before the connection is closed.
 		msg := NewMessage()
-		msg.Parse(buf[:len(buf)-1])
+		if err := msg.Parse(buf); err != nil {
+			logger.Warningf("stomp: cannot parse frame. %s", err)
+			msg.Release()
+			c.error(err)
+			return
+		}
 
 		select {
 		case <-c.done:
+			msg.Release()
+			return
+		case messages <- msg:
+		}
+	}
+}
+
+// readFrame reads the next frame from the connection, excluding the
+// null terminator. If the frame includes a content-length header the
+// body is read to the specified length, otherwise the body is read to
+// the first null byte.
+func (c *connPeer) readFrame() ([]byte, error) {
+	var (
+		buf  []byte
+		clen = -1
+		err  error
+	)
+
+	// read the method and headers up to and including
+	// the blank line that precedes the body.
+	for n := 0; ; n++ {
+		pos := len(buf)
+		buf, err = c.readUntil(buf, '\n')
+		if err != nil {
+			return nil, err
+		}
+		line := buf[pos:]
+		if len(line) == 1 {
 			break
-		default:
-			messages <- msg
+		}
+		// the first line is the method.
+		if n == 0 {
+			continue
+		}
+		if c.headers != 0 && n > c.headers {
+			return nil, errHeaders
+		}
+		if c.headerSize != 0 && len(line)-1 > c.headerSize {
+			return nil, errHeaderSize
+		}
+		if clen == -1 && bytes.HasPrefix(line, contentLen) {
+			clen = ParseInt(line[len(contentLen) : len(line)-1])
+		}
+	}
+
+	if clen == -1 {
+		buf, err = c.readUntil(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:len(buf)-1], nil
+	}
+
+	// the content-length is sent by the remote peer and must be
+	// validated before the body buffer is allocated.
+	if clen < 0 || clen > maxFrameSize {
+		return nil, errContentLength
+	}
+
+	// the content-length is checked against the frame size
+	// limit before the body buffer is allocated. The length is
+	// subtracted from the limit to prevent integer overflow.
+	pos := len(buf)
+	if clen > c.limit()-pos {
+		return nil, errFrameSize
+	}
+	buf = append(buf, make([]byte, clen+1)...)
+	if _, err := io.ReadFull(c.reader, buf[pos:]); err != nil {
+		return nil, err
+	}
+	if buf[len(buf)-1] != 0 {
+		return nil, errTerminator
+	}
+	return buf[:len(buf)-1], nil
+}
+
+// limit returns the frame size limit, bounded by the hard limit.
+func (c *connPeer) limit() int {
+	if c.frameSize == 0 || c.frameSize > maxFrameSize {
+		return maxFrameSize
+	}
+	return c.frameSize
+}
+
+// readUntil reads until the first occurrence of delim in the input,
+// appending the data, including the delimiter, to buf. An error is
+// returned if buf exceeds the frame size limit.
+func (c *connPeer) readUntil(buf []byte, delim byte) ([]byte, error) {
+	for {
+		b, err := c.reader.ReadSlice(delim)
+		buf = append(buf, b...)
+		if len(buf) > c.limit()+1 {
+			return nil, errFrameSize
+		}
+		if err != bufio.ErrBufferFull {
+			return buf, err
 		}
 	}
 }
 
+// error sends an error frame to the remote peer.
+func (c *connPeer) error(err error) {
+	msg := NewMessage()
+	msg.Method = MethodError
+	msg.Header.Add(HeaderMessage, []byte(err.Error()))
+	c.Send(msg)
+}
+
 func (c *connPeer) writeFrom(messages <-chan *Message) {
 	tick := time.NewTicker(time.Millisecond * 100).C
-	heartbeat := time.NewTicker(heartbeatTime).C
+	last := time.Now()
 
 loop:
 	for {
 		select {
 		case <-c.done:
 			break loop
-		case <-heartbeat:
-			logger.Verbosef("stomp: send heart-beat.")
-			c.writer.WriteByte(0)
 		case <-tick:
+			// send a heart-beat if nothing was written to the
+			// remote peer within the heart-beat interval.
+			if send, _ := c.heartbeat(); send != 0 && time.Since(last) >= send {
+				logger.Verbosef("stomp: send heart-beat.")
+				c.writer.Write(newline)
+				last = time.Now()
+			}
 			c.conn.SetWriteDeadline(time.Now().Add(deadline))
 			if err := c.writer.Flush(); err != nil {
 				break loop
 			}
 			c.conn.SetWriteDeadline(never)
-		case msg, ok := <-messages:
-			if !ok {
-				break loop
-			}
+		case msg := <-messages:
 			writeTo(c.writer, msg)
 			c.writer.WriteByte(0)
 			msg.Release()
+			last = time.Now()
 		}
 	}
 
@@ -145,12 +327,17 @@ loop:
 
 func (c *connPeer) drain() error {
 	c.conn.SetWriteDeadline(time.Now().Add(deadline))
-	for msg := range c.outgoing {
-		writeTo(c.writer, msg)
-		c.writer.WriteByte(0)
-		msg.Release()
+loop:
+	for {
+		select {
+		case msg := <-c.outgoing:
+			writeTo(c.writer, msg)
+			c.writer.WriteByte(0)
+			msg.Release()
+		default:
+			break loop
+		}
 	}
-	c.conn.SetWriteDeadline(never)
 	c.writer.Flush()
 	return c.conn.Close()
 }
@@ -1 +1,215 @@
 package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnHeartbeatSend(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	peer := Conn(a)
+	defer peer.Close()
+	peer.(Heartbeater).Heartbeat(time.Millisecond*50, 0)
+
+	done := make(chan byte, 1)
+	go func() {
+		c, _ := bufio.NewReader(b).ReadByte()
+		done <- c
+	}()
+
+	select {
+	case c := <-done:
+		if c != '\n' {
+			t.Errorf("Want heart-beat end-of-line, got %q", c)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want heart-beat sent within the send interval")
+	}
+}
+
+func TestConnHeartbeatRecv(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	peer := Conn(a)
+	peer.(Heartbeater).Heartbeat(0, time.Millisecond*50)
+
+	// heart-beats are written to keep the connection open.
+	for i := 0; i < 3; i++ {
+		b.Write([]byte{'\n'})
+		time.Sleep(time.Millisecond * 25)
+	}
+	b.Write([]byte("SEND\ndestination:/queue/test\n\nhello\x00"))
+
+	select {
+	case m, ok := <-peer.Receive():
+		if !ok {
+			t.Fatalf("Want connection kept open when heart-beats received")
+		}
+		if string(m.Body) != "hello" {
+			t.Errorf("Want message received after heart-beats, got %q", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Want message received")
+	}
+
+	// the remote peer stops sending data and should be
+	// considered dead.
+	select {
+	case _, ok := <-peer.Receive():
+		if ok {
+			t.Errorf("Want connection closed when heart-beats are missed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want connection closed when heart-beats are missed")
+	}
+}
+
+func TestConnContentLength(t *testing.T) {
+	a, b := net.Pipe()
+
+	sender := Conn(a)
+	defer sender.Close()
+	receiver := Conn(b)
+	defer receiver.Close()
+
+	body := []byte("hello\x00world\x00")
+	msg := NewMessage()
+	msg.Method = MethodSend
+	msg.Dest = []byte("/queue/test")
+	msg.Header.Add([]byte("reply-to"), []byte("/queue/a:b\n"))
+	msg.Body = body
+	sender.Send(msg)
+
+	select {
+	case m := <-receiver.Receive():
+		if !bytes.Equal(m.Body, body) {
+			t.Errorf("Want binary body %q, got %q", body, m.Body)
+		}
+		if got := m.Header.GetString("reply-to"); got != "/queue/a:b\n" {
+			t.Errorf("Want escaped header value round-trip, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Want message received")
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	var tests = []struct {
+		opt   ConnOption
+		frame string
+		err   error
+	}{
+		{
+			opt:   WithMaxFrameSize(32),
+			frame: "SEND\ndestination:/queue/test\n\nhello world, this frame is too large\x00",
+			err:   errFrameSize,
+		},
+		{
+			opt:   WithMaxFrameSize(32),
+			frame: "SEND\ndestination:/queue/test\ncontent-length:1000000\n\n",
+			err:   errFrameSize,
+		},
+		{
+			opt:   WithMaxFrameSize(0),
+			frame: "SEND\ndestination:/queue/test\ncontent-length:9223372036854775800\n\n",
+			err:   errContentLength,
+		},
+		{
+			opt:   WithMaxFrameSize(1 << 20),
+			frame: "SEND\ndestination:/queue/test\ncontent-length:9223372036854775800\n\n",
+			err:   errContentLength,
+		},
+		{
+			opt:   WithMaxFrameSize(1 << 20),
+			frame: "SEND\ndestination:/queue/test\ncontent-length:67108000\n\n",
+			err:   errFrameSize,
+		},
+		{
+			opt:   WithMaxHeaders(2),
+			frame: "SEND\ndestination:/queue/test\nfoo:bar\nbaz:qux\n\nhello\x00",
+			err:   errHeaders,
+		},
+		{
+			opt:   WithMaxHeaderSize(16),
+			frame: "SEND\ndestination:/queue/test\n\nhello\x00",
+			err:   errHeaderSize,
+		},
+		{
+			opt:   WithMaxFrameSize(1 << 20),
+			frame: "SEND\ndestination:/queue/test\nfoo:a\\qb\n\nhello\x00",
+			err:   errEscape,
+		},
+	}
+
+	for _, test := range tests {
+		a, b := net.Pipe()
+		peer := Conn(a, test.opt)
+
+		go b.Write([]byte(test.frame))
+
+		reader := bufio.NewReader(b)
+		frame, err := reader.ReadBytes(0)
+		if err != nil {
+			t.Errorf("Want error frame sent when the frame is rejected. %s", err)
+			b.Close()
+			continue
+		}
+		msg := NewMessage()
+		msg.Parse(frame[:len(frame)-1])
+		if !bytes.Equal(msg.Method, MethodError) {
+			t.Errorf("Want error frame, got %s", msg.Method)
+		}
+		if got := msg.Header.GetString("message"); got != test.err.Error() {
+			t.Errorf("Want error message %q, got %q", test.err, got)
+		}
+
+		select {
+		case _, ok := <-peer.Receive():
+			if ok {
+				t.Errorf("Want connection closed when the frame is rejected")
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Want connection closed when the frame is rejected")
+		}
+		b.Close()
+	}
+}
+
+func TestConnHardLimit(t *testing.T) {
+	defer func(size int) {
+		maxFrameSize = size
+	}(maxFrameSize)
+	maxFrameSize = 64
+
+	// the hard limit applies when the frame size limit is removed.
+	a, b := net.Pipe()
+	defer b.Close()
+	peer := Conn(a, WithMaxFrameSize(0))
+
+	go b.Write([]byte("SEND\ndestination:/queue/test\n\nhello world, this frame exceeds the hard limit on the frame size\x00"))
+
+	frame, err := bufio.NewReader(b).ReadBytes(0)
+	if err != nil {
+		t.Fatalf("Want error frame sent when hard limit exceeded. %s", err)
+	}
+	msg := NewMessage()
+	msg.Parse(frame[:len(frame)-1])
+	if got := msg.Header.GetString("message"); got != errFrameSize.Error() {
+		t.Errorf("Want error message %q, got %q", errFrameSize, got)
+	}
+
+	select {
+	case _, ok := <-peer.Receive():
+		if ok {
+			t.Errorf("Want connection closed when hard limit exceeded")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want connection closed when hard limit exceeded")
+	}
+}
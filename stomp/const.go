@@ -17,46 +17,72 @@ var (
 	MethodMessage     = []byte("MESSAGE")
 	MethodRecipet     = []byte("RECEIPT")
 	MethodError       = []byte("ERROR")
+	MethodBegin       = []byte("BEGIN")
+	MethodCommit      = []byte("COMMIT")
+	MethodAbort       = []byte("ABORT")
 )
 
 // STOMP protocol headers.
 var (
 	HeaderAccept       = []byte("accept-version")
 	HeaderAck          = []byte("ack")
+	HeaderAckTimeout   = []byte("ack-timeout")
+	HeaderClientID     = []byte("client-id")
+	HeaderContentLen   = []byte("content-length")
+	HeaderCorrelation  = []byte("correlation-id")
+	HeaderConsumerPri  = []byte("consumer-priority")
+	HeaderExclusive    = []byte("exclusive")
 	HeaderExpires      = []byte("expires")
+	HeaderExtend       = []byte("extend")
+	HeaderHeartbeat    = []byte("heart-beat")
+	HeaderDelay        = []byte("delay")
+	HeaderDeliverAt    = []byte("deliver-at")
 	HeaderDest         = []byte("destination")
+	HeaderDurable      = []byte("durable")
+	HeaderGroup        = []byte("group")
 	HeaderHost         = []byte("host")
 	HeaderLogin        = []byte("login")
 	HeaderPass         = []byte("passcode")
 	HeaderID           = []byte("id")
+	HeaderMessage      = []byte("message")
 	HeaderMessageID    = []byte("message-id")
+	HeaderOrigDest     = []byte("original-destination")
 	HeaderPersist      = []byte("persist")
 	HeaderPrefetch     = []byte("prefetch-count")
+	HeaderPriority     = []byte("priority")
 	HeaderReceipt      = []byte("receipt")
 	HeaderReceiptID    = []byte("receipt-id")
+	HeaderReason       = []byte("reason")
+	HeaderRedelivery   = []byte("redelivery-count")
+	HeaderRedeliveries = []byte("max-redeliveries")
+	HeaderReplyTo      = []byte("reply-to")
 	HeaderRetain       = []byte("retain")
 	HeaderSelector     = []byte("selector")
 	HeaderServer       = []byte("server")
 	HeaderSession      = []byte("session")
 	HeaderSubscription = []byte("subscription")
+	HeaderTransaction  = []byte("transaction")
 	HeaderVersion      = []byte("version")
 )
 
 // Common STOMP header values.
 var (
-	AckAuto      = []byte("auto")
-	AckClient    = []byte("client")
-	PersistTrue  = []byte("true")
-	RetainTrue   = []byte("true")
-	RetainLast   = []byte("last")
-	RetainAll    = []byte("all")
-	RetainRemove = []byte("remove")
+	AckAuto             = []byte("auto")
+	AckClient           = []byte("client")
+	AckClientIndividual = []byte("client-individual")
+	PersistTrue         = []byte("true")
+	RetainTrue          = []byte("true")
+	RetainLast          = []byte("last")
+	RetainAll           = []byte("all")
+	RetainRemove        = []byte("remove")
 )
 
 var headerLookup = map[string]struct{}{
 	"accept-version": struct{}{},
 	"ack":            struct{}{},
+	"content-length": struct{}{},
 	"expires":        struct{}{},
+	"heart-beat":     struct{}{},
 	"destination":    struct{}{},
 	"host":           struct{}{},
 	"login":          struct{}{},
@@ -72,5 +98,6 @@ var headerLookup = map[string]struct{}{
 	"server":         struct{}{},
 	"session":        struct{}{},
 	"subscription":   struct{}{},
+	"transaction":    struct{}{},
 	"version":        struct{}{},
 }
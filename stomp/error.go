@@ -0,0 +1,22 @@
+package stomp
+
+// Error represents an ERROR message sent by the server.
+type Error struct {
+	Message string // message header
+	Body    []byte // message body, which typically includes the failed message
+}
+
+func newError(m *Message) *Error {
+	return &Error{
+		Message: string(m.Header.Get(HeaderMessage)),
+		Body:    append([]byte(nil), m.Body...),
+	}
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "stomp: server error"
+	}
+	return e.Message
+}
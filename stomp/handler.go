@@ -1,5 +1,25 @@
 package stomp
 
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/drone/mq/logger"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	errJSONHandler    = errors.New("stomp: json handler must be a func(context.Context, *T) error")
+	errJSONCumulative = errors.New("stomp: json handler cannot use cumulative acknowledgement with concurrency")
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
 // Handler handles a STOMP message.
 type Handler interface {
 	Handle(*Message)
@@ -11,3 +31,77 @@ type HandlerFunc func(*Message)
 
 // Handle calls f(m).
 func (f HandlerFunc) Handle(m *Message) { f(m) }
+
+// jsonHandler decodes the JSON-encoded message body and invokes
+// a func(context.Context, *T) error, acknowledging the message
+// based on the result.
+type jsonHandler struct {
+	client *Client
+	fn     reflect.Value
+	typ    reflect.Type
+}
+
+// newJSONHandler returns a jsonHandler invoking fn, or an
+// error if fn is not a func(context.Context, *T) error.
+func newJSONHandler(client *Client, fn interface{}) (*jsonHandler, error) {
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func ||
+		t.NumIn() != 2 || t.NumOut() != 1 ||
+		t.In(0) != contextType ||
+		t.In(1).Kind() != reflect.Ptr ||
+		t.Out(0) != errorType {
+		return nil, errJSONHandler
+	}
+	return &jsonHandler{
+		client: client,
+		fn:     reflect.ValueOf(fn),
+		typ:    t.In(1).Elem(),
+	}, nil
+}
+
+// Handle handles the message. If the message requires acknowledgement
+// it is acknowledged on success, or negative-acknowledged if the body
+// cannot be decoded or the handler returns an error or panics.
+func (h *jsonHandler) Handle(m *Message) {
+	defer m.Release()
+
+	err := h.call(m)
+	if err != nil {
+		logger.Warningf("stomp client: cannot handle message %s: %s",
+			string(m.ID),
+			err,
+		)
+	}
+
+	// the subscription ack mode determines whether the
+	// message includes an ack id.
+	if len(m.Ack) == 0 {
+		return
+	}
+	id := append([]byte(nil), m.Ack...)
+	if err != nil {
+		h.client.Nack(id)
+	} else {
+		h.client.Ack(id)
+	}
+}
+
+// call decodes the message body and invokes the handler,
+// returning an error if the handler panics.
+func (h *jsonHandler) call(m *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+
+	v := reflect.New(h.typ)
+	if err := m.Unmarshal(v.Interface()); err != nil {
+		return err
+	}
+	out := h.fn.Call([]reflect.Value{
+		reflect.ValueOf(m.Context()), v,
+	})
+	err, _ = out[0].Interface().(error)
+	return err
+}
@@ -76,6 +76,30 @@ func (h *Header) Add(name, data []byte) {
 	h.itemc++
 }
 
+// Set sets the named header value, replacing the existing value.
+func (h *Header) Set(name, data []byte) {
+	for i := 0; i < h.itemc; i++ {
+		if bytes.Equal(h.items[i].name, name) {
+			h.items[i].data = data
+			return
+		}
+	}
+	h.Add(name, data)
+}
+
+// Del deletes the named header.
+func (h *Header) Del(name []byte) {
+	for i := 0; i < h.itemc; i++ {
+		if bytes.Equal(h.items[i].name, name) {
+			copy(h.items[i:], h.items[i+1:h.itemc])
+			h.itemc--
+			h.items[h.itemc].name = zeroBytes
+			h.items[h.itemc].data = zeroBytes
+			return
+		}
+	}
+}
+
 // Index returns the keypair at index i.
 func (h *Header) Index(i int) (k, v []byte) {
 	if i > h.itemc {
@@ -92,7 +116,7 @@ func (h *Header) Len() int {
 }
 
 func (h *Header) grow() {
-	if h.itemc > defaultHeaderLen-1 {
+	if h.itemc > len(h.items)-1 {
 		h.items = append(h.items, item{})
 	}
 }
@@ -77,3 +77,34 @@ func TestHeader(t *testing.T) {
 		t.Errorf("Expect header.GetBool parses the boolean value false")
 	}
 }
+
+func TestHeaderSet(t *testing.T) {
+	header := newHeader()
+	header.Set([]byte("foo"), []byte("bar"))
+	if got := header.GetString("foo"); got != "bar" {
+		t.Errorf("Expect header.Set adds the header when it does not exist")
+	}
+	header.Set([]byte("foo"), []byte("baz"))
+	if got := header.GetString("foo"); got != "baz" {
+		t.Errorf("Expect header.Set replaces the existing header value")
+	}
+	if got := header.Len(); got != 1 {
+		t.Errorf("Want header length 1, got %d", got)
+	}
+}
+
+func TestHeaderDel(t *testing.T) {
+	header := newHeader()
+	header.Add([]byte("foo"), []byte("bar"))
+	header.Add([]byte("baz"), []byte("qux"))
+	header.Del([]byte("foo"))
+	if got := header.Get([]byte("foo")); len(got) != 0 {
+		t.Errorf("Expect header.Del removes the header")
+	}
+	if got := header.GetString("baz"); got != "qux" {
+		t.Errorf("Expect header.Del retains the remaining headers")
+	}
+	if got := header.Len(); got != 1 {
+		t.Errorf("Want header length 1, got %d", got)
+	}
+}
@@ -0,0 +1,59 @@
+package stomp
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// Heartbeater is implemented by peers that send and monitor heart-beats.
+type Heartbeater interface {
+	// Heartbeat configures the interval at which heart-beats are sent to
+	// the remote peer, and the interval within which the remote peer is
+	// expected to send data. A zero interval disables heart-beating.
+	Heartbeat(send, recv time.Duration)
+}
+
+// FormatHeartbeat returns the heart-beat header value for the given
+// send and receive intervals.
+func FormatHeartbeat(send, recv time.Duration) []byte {
+	b := strconv.AppendInt(nil, int64(send/time.Millisecond), 10)
+	b = append(b, ',')
+	return strconv.AppendInt(b, int64(recv/time.Millisecond), 10)
+}
+
+// ParseHeartbeat parses the heart-beat header value and returns the
+// send and receive intervals.
+func ParseHeartbeat(b []byte) (send, recv time.Duration) {
+	i := bytes.IndexByte(b, ',')
+	if i == -1 {
+		return
+	}
+	send = time.Duration(ParseInt64(b[:i])) * time.Millisecond
+	recv = time.Duration(ParseInt64(b[i+1:])) * time.Millisecond
+	return
+}
+
+// NegotiateHeartbeat returns the interval at which heart-beats should be
+// sent to the remote peer and the interval within which the remote peer
+// is expected to send data, given the local and remote heart-beat header
+// values, as defined by the STOMP 1.2 specification.
+func NegotiateHeartbeat(local, remote []byte) (send, recv time.Duration) {
+	localSend, localRecv := ParseHeartbeat(local)
+	remoteSend, remoteRecv := ParseHeartbeat(remote)
+
+	if localSend != 0 && remoteRecv != 0 {
+		send = maxDuration(localSend, remoteRecv)
+	}
+	if localRecv != 0 && remoteSend != 0 {
+		recv = maxDuration(localRecv, remoteSend)
+	}
+	return
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,46 @@
+package stomp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatHeartbeat(t *testing.T) {
+	got := FormatHeartbeat(time.Second, time.Millisecond*1500)
+	if string(got) != "1000,1500" {
+		t.Errorf("Want heart-beat header 1000,1500, got %s", got)
+	}
+}
+
+func TestParseHeartbeat(t *testing.T) {
+	send, recv := ParseHeartbeat([]byte("1000,1500"))
+	if send != time.Second || recv != time.Millisecond*1500 {
+		t.Errorf("Want heart-beat 1s,1.5s, got %s,%s", send, recv)
+	}
+	send, recv = ParseHeartbeat([]byte("1000"))
+	if send != 0 || recv != 0 {
+		t.Errorf("Want zero heart-beat when malformed, got %s,%s", send, recv)
+	}
+}
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	var tests = []struct {
+		local  string
+		remote string
+		send   time.Duration
+		recv   time.Duration
+	}{
+		{"", "", 0, 0},
+		{"0,0", "1000,1000", 0, 0},
+		{"1000,0", "0,2000", time.Second * 2, 0},
+		{"0,1000", "2000,0", 0, time.Second * 2},
+		{"3000,3000", "1000,1000", time.Second * 3, time.Second * 3},
+	}
+	for _, test := range tests {
+		send, recv := NegotiateHeartbeat([]byte(test.local), []byte(test.remote))
+		if send != test.send || recv != test.recv {
+			t.Errorf("Want heart-beat %s,%s negotiated from %q and %q, got %s,%s",
+				test.send, test.recv, test.local, test.remote, send, recv)
+		}
+	}
+}
@@ -12,23 +12,25 @@ import (
 
 // Message represents a parsed STOMP message.
 type Message struct {
-	ID       []byte // id header
-	Proto    []byte // stomp version
-	Method   []byte // stomp method
-	User     []byte // username header
-	Pass     []byte // password header
-	Dest     []byte // destination header
-	Subs     []byte // subscription id
-	Ack      []byte // ack id
-	Msg      []byte // message-id header
-	Persist  []byte // persist header
-	Retain   []byte // retain header
-	Prefetch []byte // prefetch count
-	Expires  []byte // expires header
-	Receipt  []byte // receipt header
-	Selector []byte // selector header
-	Body     []byte
-	Header   *Header // custom headers
+	ID        []byte // id header
+	Proto     []byte // stomp version
+	Method    []byte // stomp method
+	User      []byte // username header
+	Pass      []byte // password header
+	Dest      []byte // destination header
+	Subs      []byte // subscription id
+	Ack       []byte // ack id
+	Msg       []byte // message-id header
+	Persist   []byte // persist header
+	Retain    []byte // retain header
+	Prefetch  []byte // prefetch count
+	Expires   []byte // expires header
+	Receipt   []byte // receipt header
+	Selector  []byte // selector header
+	Heartbeat []byte // heart-beat header
+	Tx        []byte // transaction header
+	Body      []byte
+	Header    *Header // custom headers
 
 	ctx context.Context
 }
@@ -50,10 +52,12 @@ func (m *Message) Copy() *Message {
 	c.Retain = m.Retain
 	c.Receipt = m.Receipt
 	c.Expires = m.Expires
+	c.Heartbeat = m.Heartbeat
+	c.Tx = m.Tx
 	c.Body = m.Body
 	c.ctx = m.ctx
 	c.Header.itemc = m.Header.itemc
-	copy(c.Header.items, m.Header.items)
+	c.Header.items = append(c.Header.items[:0], m.Header.items[:m.Header.itemc]...)
 	return c
 }
 
@@ -103,6 +107,8 @@ func (m *Message) Reset() {
 	m.Retain = m.Retain[:0]
 	m.Receipt = m.Receipt[:0]
 	m.Expires = m.Expires[:0]
+	m.Heartbeat = m.Heartbeat[:0]
+	m.Tx = m.Tx[:0]
 	m.Body = m.Body[:0]
 	m.ctx = nil
 	m.Header.reset()
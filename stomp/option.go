@@ -4,11 +4,104 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // MessageOption configures message options.
 type MessageOption func(*Message)
 
+// ClientOption configures client options.
+type ClientOption func(*Client)
+
+// ConnOption configures network connection options.
+type ConnOption func(*connPeer)
+
+// WithMaxFrameSize returns a ConnOption which limits the size in bytes
+// of frames received from the remote peer. If the limit is exceeded an
+// error frame is sent and the connection is closed. Frames are always
+// limited to 64MB, even if the limit is zero.
+func WithMaxFrameSize(size int) ConnOption {
+	return func(c *connPeer) {
+		c.frameSize = size
+	}
+}
+
+// WithMaxHeaders returns a ConnOption which limits the number of headers
+// in frames received from the remote peer. If the limit is exceeded an
+// error frame is sent and the connection is closed.
+func WithMaxHeaders(count int) ConnOption {
+	return func(c *connPeer) {
+		c.headers = count
+	}
+}
+
+// WithMaxHeaderSize returns a ConnOption which limits the length in bytes
+// of each header in frames received from the remote peer. If the limit is
+// exceeded an error frame is sent and the connection is closed.
+func WithMaxHeaderSize(size int) ConnOption {
+	return func(c *connPeer) {
+		c.headerSize = size
+	}
+}
+
+// WithBackoff returns a ClientOption which configures the initial and
+// maximum wait between reconnect attempts.
+func WithBackoff(min, max time.Duration) ClientOption {
+	return func(c *Client) {
+		c.backoff = min
+		c.maxWait = max
+	}
+}
+
+// WithTimeout returns a ClientOption which configures the default time
+// the client waits for the server to accept the session, or to return a
+// requested receipt. The Context variants of the client methods are
+// bounded by the given context instead. If zero, the client waits
+// indefinitely.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithSendBuffer returns a ClientOption which configures the number of
+// messages buffered while the client is reconnecting. If zero, sending a
+// message while reconnecting returns an error.
+func WithSendBuffer(size int) ClientOption {
+	return func(c *Client) {
+		c.buffer = size
+	}
+}
+
+// WithAckTimeout returns a MessageOption configured with the time a
+// subscriber has to acknowledge a message before it is redelivered. The
+// timeout can be reset using Client.Extend.
+func WithAckTimeout(d time.Duration) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderAckTimeout,
+			strconv.AppendInt(nil, int64(d/time.Millisecond), 10),
+		)
+	}
+}
+
+// WithClientID returns a MessageOption configured with the client id,
+// used when establishing the session. The client id identifies the
+// durable subscriptions of the client across sessions.
+func WithClientID(id string) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderClientID, []byte(id))
+	}
+}
+
+// WithCorrelationID returns a MessageOption configured with the
+// correlation id, used to match a reply to the request.
+func WithCorrelationID(id string) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderCorrelation, []byte(id))
+	}
+}
+
 // WithCredentials returns a MessageOption which sets credentials.
 func WithCredentials(username, password string) MessageOption {
 	return func(m *Message) {
@@ -45,6 +138,25 @@ func WithHeaders(headers map[string]string) MessageOption {
 	}
 }
 
+// WithDurable returns a MessageOption configured with the durable
+// subscription name. Topic messages published while the client is
+// disconnected are buffered and delivered when the client reconnects
+// with the same login, client id and subscription name.
+func WithDurable(name string) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderDurable, []byte(name))
+	}
+}
+
+// WithExclusive returns a MessageOption configured to subscribe as an
+// exclusive consumer. Only one exclusive consumer receives messages from
+// a queue, and the next exclusive consumer takes over if it disconnects.
+func WithExclusive() MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderExclusive, []byte("true"))
+	}
+}
+
 // WithExpires returns a MessageOption configured with an expiration.
 func WithExpires(exp int64) MessageOption {
 	return func(m *Message) {
@@ -52,6 +164,68 @@ func WithExpires(exp int64) MessageOption {
 	}
 }
 
+// WithDelay returns a MessageOption configured to delay delivery of
+// the message by the given duration.
+func WithDelay(d time.Duration) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderDelay,
+			strconv.AppendInt(nil, int64(d/time.Millisecond), 10),
+		)
+	}
+}
+
+// WithDeliverAt returns a MessageOption configured to delay delivery
+// of the message until the given time.
+func WithDeliverAt(t time.Time) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderDeliverAt,
+			strconv.AppendInt(nil, t.UnixNano()/int64(time.Millisecond), 10),
+		)
+	}
+}
+
+// WithGroup returns a MessageOption configured with the message group.
+// Queue messages in the same group are delivered to the same subscriber
+// until it unsubscribes or disconnects.
+func WithGroup(group string) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderGroup, []byte(group))
+	}
+}
+
+// WithHeartbeat returns a MessageOption configured with the heart-beat
+// send and receive intervals, used when establishing the session.
+func WithHeartbeat(send, recv time.Duration) MessageOption {
+	return func(m *Message) {
+		m.Heartbeat = FormatHeartbeat(send, recv)
+	}
+}
+
+// WithConcurrency returns a MessageOption which configures the subscription
+// to invoke the handler using a pool of n workers, so that a slow handler
+// does not block other subscriptions. Messages are handled in order when n
+// is 1. If all workers are busy, receiving messages from the server blocks
+// until a worker is available, which can be bounded using WithPrefetch.
+func WithConcurrency(n int) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(headerConcurrency, strconv.AppendInt(nil, int64(n), 10))
+	}
+}
+
+// WithConsumerPriority returns a MessageOption configured with the
+// subscriber priority. If the queue uses priority dispatch, messages
+// are delivered to the subscriber with the highest priority first.
+func WithConsumerPriority(priority int) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderConsumerPri,
+			strconv.AppendInt(nil, int64(priority), 10),
+		)
+	}
+}
+
 // WithPrefetch returns a MessageOption configured with a prefetch count.
 func WithPrefetch(prefetch int) MessageOption {
 	return func(m *Message) {
@@ -59,6 +233,18 @@ func WithPrefetch(prefetch int) MessageOption {
 	}
 }
 
+// WithPriority returns a MessageOption configured with the message
+// priority, from 0 (lowest) to 9 (highest). Queues deliver messages with
+// a higher priority first. The default priority is 4.
+func WithPriority(priority int) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderPriority,
+			strconv.AppendInt(nil, int64(priority), 10),
+		)
+	}
+}
+
 // WithReceipt returns a MessageOption configured with a receipt request.
 func WithReceipt() MessageOption {
 	return func(m *Message) {
@@ -73,7 +259,29 @@ func WithPersistence() MessageOption {
 	}
 }
 
+// WithMaxRedeliveries returns a MessageOption configured with the maximum
+// number of redeliveries, after which the message is moved to the
+// dead-letter queue.
+func WithMaxRedeliveries(max int) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(
+			HeaderRedeliveries,
+			strconv.AppendInt(nil, int64(max), 10),
+		)
+	}
+}
+
+// WithReplyTo returns a MessageOption configured with the destination
+// to which replies to the message are sent.
+func WithReplyTo(dest string) MessageOption {
+	return func(m *Message) {
+		m.Header.Set(HeaderReplyTo, []byte(dest))
+	}
+}
+
 // WithRetain returns a MessageOption configured to retain the message.
+// The retain value is last, all, remove, or the number of recent messages
+// the topic should retain.
 func WithRetain(retain string) MessageOption {
 	return func(m *Message) {
 		m.Retain = []byte(retain)
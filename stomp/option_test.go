@@ -3,6 +3,7 @@ package stomp
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func TestOptions(t *testing.T) {
@@ -75,6 +76,104 @@ func TestOptions(t *testing.T) {
 		t.Errorf("Want WithReceipt to apply receipt header")
 	}
 
+	opt = WithDelay(time.Second)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderDelay); string(v) != "1000" {
+		t.Errorf("Want WithDelay to apply delay header in milliseconds")
+	}
+
+	opt = WithDeliverAt(time.Unix(1, 0))
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderDeliverAt); string(v) != "1000" {
+		t.Errorf("Want WithDeliverAt to apply deliver-at header in milliseconds")
+	}
+
+	opt = WithHeartbeat(time.Second, time.Second*2)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if !bytes.Equal(msg.Heartbeat, []byte("1000,2000")) {
+		t.Errorf("Want WithHeartbeat to apply heart-beat header")
+	}
+
+	opt = WithMaxRedeliveries(3)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderRedeliveries); string(v) != "3" {
+		t.Errorf("Want WithMaxRedeliveries to apply max-redeliveries header")
+	}
+
+	opt = WithConsumerPriority(10)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderConsumerPri); string(v) != "10" {
+		t.Errorf("Want WithConsumerPriority to apply consumer-priority header")
+	}
+
+	opt = WithPriority(9)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderPriority); string(v) != "9" {
+		t.Errorf("Want WithPriority to apply priority header")
+	}
+
+	opt = WithExclusive()
+	msg = NewMessage()
+	msg.Apply(opt)
+	if !msg.Header.GetBool("exclusive") {
+		t.Errorf("Want WithExclusive to apply exclusive header")
+	}
+
+	opt = WithGroup("octocat/hello-world")
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderGroup); string(v) != "octocat/hello-world" {
+		t.Errorf("Want WithGroup to apply group header")
+	}
+
+	opt = WithClientID("dashboard")
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderClientID); string(v) != "dashboard" {
+		t.Errorf("Want WithClientID to apply client-id header")
+	}
+
+	opt = WithDurable("builds")
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderDurable); string(v) != "builds" {
+		t.Errorf("Want WithDurable to apply durable header")
+	}
+
+	opt = WithReplyTo("/temp-queue/1")
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderReplyTo); string(v) != "/temp-queue/1" {
+		t.Errorf("Want WithReplyTo to apply reply-to header")
+	}
+
+	opt = WithCorrelationID("2")
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderCorrelation); string(v) != "2" {
+		t.Errorf("Want WithCorrelationID to apply correlation-id header")
+	}
+
+	opt = WithConcurrency(4)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(headerConcurrency); string(v) != "4" {
+		t.Errorf("Want WithConcurrency to apply concurrency header")
+	}
+
+	opt = WithAckTimeout(time.Minute)
+	msg = NewMessage()
+	msg.Apply(opt)
+	if v := msg.Header.Get(HeaderAckTimeout); string(v) != "60000" {
+		t.Errorf("Want WithAckTimeout to apply ack-timeout header")
+	}
+
 	opt = WithRetain("last")
 	msg = NewMessage()
 	msg.Apply(opt)
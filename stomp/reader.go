@@ -2,14 +2,20 @@ package stomp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 )
 
 func read(input []byte, m *Message) (err error) {
 	var (
-		pos int
-		off int
-		tot = len(input)
+		pos  int
+		off  int
+		tot  = len(input)
+		clen = -1
+
+		// the connect and connected frames do not escape
+		// header values for backward compatibility.
+		escaped bool
 	)
 
 	// parse the stomp message
@@ -24,6 +30,7 @@ func read(input []byte, m *Message) (err error) {
 			break
 		}
 	}
+	escaped = escapeHeaders(m)
 
 	// parse the stomp headers
 	for {
@@ -55,13 +62,36 @@ func read(input []byte, m *Message) (err error) {
 				pos = off
 				break loop
 			case ':':
+				// the header name is terminated by the first
+				// colon. Subsequent colons are part of the value.
+				if name != nil {
+					continue
+				}
 				name = input[pos:off]
-				off++
-				pos = off
+				pos = off + 1
+			}
+		}
+
+		if escaped {
+			if name, err = unescape(name); err != nil {
+				return err
+			}
+			if value, err = unescape(value); err != nil {
+				return err
 			}
 		}
 
 		switch {
+		case bytes.Equal(name, HeaderContentLen):
+			// if the header is repeated only the first
+			// occurrence is used.
+			if clen != -1 {
+				continue
+			}
+			if len(value) == 0 || ParseInt(value) == 0 && !bytes.Equal(value, zero) {
+				return errContentLength
+			}
+			clen = ParseInt(value)
 		case bytes.Equal(name, HeaderAccept):
 			m.Proto = value
 		case bytes.Equal(name, HeaderAck):
@@ -70,6 +100,8 @@ func read(input []byte, m *Message) (err error) {
 			m.Dest = value
 		case bytes.Equal(name, HeaderExpires):
 			m.Expires = value
+		case bytes.Equal(name, HeaderHeartbeat):
+			m.Heartbeat = value
 		case bytes.Equal(name, HeaderLogin):
 			m.User = value
 		case bytes.Equal(name, HeaderPass):
@@ -92,6 +124,8 @@ func read(input []byte, m *Message) (err error) {
 			m.Selector = value
 		case bytes.Equal(name, HeaderSubscription):
 			m.Subs = value
+		case bytes.Equal(name, HeaderTransaction):
+			m.Tx = value
 		case bytes.Equal(name, HeaderVersion):
 			m.Proto = value
 		default:
@@ -99,12 +133,59 @@ func read(input []byte, m *Message) (err error) {
 		}
 	}
 
-	if tot > pos {
+	switch {
+	case clen > tot-pos:
+		return fmt.Errorf("stomp: unexpected eof")
+	case clen > 0:
+		m.Body = input[pos : pos+clen]
+	case clen == -1 && tot > pos:
 		m.Body = input[pos:]
 	}
 	return
 }
 
+var (
+	zero = []byte{'0'}
+
+	errContentLength = errors.New("stomp: invalid content-length")
+	errEscape        = errors.New("stomp: invalid escape sequence")
+)
+
+// unescape decodes the escaped header octets in place and returns
+// the decoded slice. An undefined escape sequence is an error.
+func unescape(b []byte) ([]byte, error) {
+	i := bytes.IndexByte(b, '\\')
+	if i == -1 {
+		return b, nil
+	}
+	n := i
+	for ; i < len(b); i++ {
+		if b[i] != '\\' {
+			b[n] = b[i]
+			n++
+			continue
+		}
+		i++
+		if i == len(b) {
+			return nil, errEscape
+		}
+		switch b[i] {
+		case 'n':
+			b[n] = '\n'
+		case 'r':
+			b[n] = '\r'
+		case 'c':
+			b[n] = ':'
+		case '\\':
+			b[n] = '\\'
+		default:
+			return nil, errEscape
+		}
+		n++
+	}
+	return b[:n], nil
+}
+
 const (
 	asciiZero = 48
 	asciiNine = 57
@@ -27,12 +27,16 @@ func TestRead(t *testing.T) {
 // and should not cause the parser to blow up.
 func TestReadMalformed(t *testing.T) {
 	var tests = []string{
-		"",                       // no header
-		"STOMP",                  // no header newline
-		"STOMP\nversion",         // no header separator
-		"STOMP\nversion:",        // no header value
-		"STOMP\nversion:1.1.2",   // no header newline
-		"STOMP\nversion:1.1.2\n", // no newline before eof
+		"",                                   // no header
+		"STOMP",                              // no header newline
+		"STOMP\nversion",                     // no header separator
+		"STOMP\nversion:",                    // no header value
+		"STOMP\nversion:1.1.2",               // no header newline
+		"STOMP\nversion:1.1.2\n",             // no newline before eof
+		"SEND\nfoo:b\\tr\n\n",                // undefined escape sequence
+		"SEND\nfoo:bar\\\n\n",                // incomplete escape sequence
+		"SEND\ncontent-length:10\n\nhello",   // content-length exceeds body
+		"SEND\ncontent-length:five\n\nhello", // invalid content-length
 	}
 
 	for _, test := range tests {
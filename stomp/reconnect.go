@@ -0,0 +1,220 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drone/mq/logger"
+	"github.com/drone/mq/stomp/dialer"
+)
+
+var (
+	// ErrDisconnected is returned when a message cannot be sent because
+	// the client is disconnected from the server.
+	ErrDisconnected = errors.New("stomp: client disconnected")
+
+	// ErrBufferFull is returned when a message cannot be sent because the
+	// client is disconnected from the server and the send buffer is full.
+	ErrBufferFull = errors.New("stomp: client send buffer full")
+)
+
+var (
+	defaultBackoff = time.Second
+	defaultMaxWait = time.Minute
+	connectWait    = time.Second * 30
+)
+
+// subscriber tracks an active subscription so that it can be
+// restored when the client reconnects.
+type subscriber struct {
+	dest    string
+	handler Handler
+	opts    []MessageOption
+
+	// pool invokes the handler if the subscription is
+	// configured with concurrency, otherwise nil.
+	pool *workerPool
+}
+
+// DialReconnect creates a client connection to the given target. If the
+// connection is lost the client automatically reconnects, using an
+// exponential backoff, and restores the session and active subscriptions.
+func DialReconnect(target string, opts ...ClientOption) (*Client, error) {
+	client, err := Dial(target)
+	if err != nil {
+		return nil, err
+	}
+	client.target = target
+	client.backoff = defaultBackoff
+	client.maxWait = defaultMaxWait
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client, nil
+}
+
+// send writes the message to the peer. If the client is reconnecting
+// the message is buffered until the connection is restored, or rejected
+// if the buffer is full.
+func (c *Client) send(m *Message) error {
+	c.mu.Lock()
+	if !c.offline {
+		peer := c.peer
+		c.mu.Unlock()
+		return peer.Send(m)
+	}
+	defer c.mu.Unlock()
+
+	switch {
+	case bytes.Equal(m.Method, MethodSubscribe),
+		bytes.Equal(m.Method, MethodUnsubscribe):
+		// subscriptions are restored from the subscription list
+		// when the client reconnects, and do not need to be buffered.
+		if receiptc, ok := c.wait[string(m.Receipt)]; ok {
+			receiptc <- nil
+		}
+		m.Release()
+		return nil
+	case !bytes.Equal(m.Method, MethodSend), len(m.Tx) != 0:
+		// acknowledgements and transactions are bound to the
+		// session and cannot be sent once it is terminated.
+		m.Release()
+		return ErrDisconnected
+	case len(c.pending) >= c.buffer:
+		m.Release()
+		return ErrBufferFull
+	}
+	c.pending = append(c.pending, m)
+	return nil
+}
+
+// reconnect re-establishes the connection to the server using an
+// exponential backoff. It returns false if the client is disconnected
+// before the connection is restored.
+func (c *Client) reconnect() bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.offline = true
+
+	// receipts for messages sent using the previous connection
+	// will never be received.
+	for _, receiptc := range c.wait {
+		select {
+		case receiptc <- ErrDisconnected:
+		default:
+		}
+	}
+	c.mu.Unlock()
+
+	wait := c.backoff
+	for {
+		logger.Noticef("stomp client: reconnecting to %s", c.target)
+
+		err := c.redial()
+		if err == nil {
+			logger.Noticef("stomp client: reconnected to %s", c.target)
+			return true
+		}
+		if err == ErrDisconnected {
+			return false
+		}
+
+		logger.Warningf("stomp client: reconnect failed, retry in %s. %s", wait, err)
+
+		select {
+		case <-c.quit:
+			return false
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > c.maxWait {
+			wait = c.maxWait
+		}
+	}
+}
+
+// redial dials the server, establishes the session and restores the
+// active subscriptions and buffered messages.
+func (c *Client) redial() error {
+	conn, err := dialer.Dial(c.target)
+	if err != nil {
+		return err
+	}
+	peer := Conn(conn)
+
+	c.mu.Lock()
+	m := NewMessage()
+	m.Proto = STOMP
+	m.Method = MethodStomp
+	m.Heartbeat = FormatHeartbeat(heartbeatTime, heartbeatTime)
+	m.Apply(c.opts...)
+	beat := m.Heartbeat
+	c.mu.Unlock()
+
+	if err := peer.Send(m); err != nil {
+		peer.Close()
+		return err
+	}
+
+	wait := connectWait
+	if c.timeout != 0 {
+		wait = c.timeout
+	}
+
+	var ok bool
+	select {
+	case m, ok = <-peer.Receive():
+	case <-time.After(wait):
+	}
+	if !ok {
+		peer.Close()
+		return io.EOF
+	}
+	defer m.Release()
+
+	switch {
+	case bytes.Equal(m.Method, MethodError):
+		peer.Close()
+		return newError(m)
+	case !bytes.Equal(m.Method, MethodConnected):
+		peer.Close()
+		return fmt.Errorf("stomp: inbound message: unexpected method, want connected")
+	}
+
+	heartbeat(peer, beat, m.Heartbeat)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the client may be disconnected while the session is
+	// established, in which case the connection is closed.
+	if c.closed {
+		peer.Close()
+		return ErrDisconnected
+	}
+
+	for id, sub := range c.subs {
+		m := NewMessage()
+		m.Method = MethodSubscribe
+		m.ID = []byte(id)
+		m.Dest = []byte(sub.dest)
+		m.Apply(sub.opts...)
+		m.Header.Del(headerConcurrency)
+		m.Receipt = nil
+		peer.Send(m)
+	}
+	for _, m := range c.pending {
+		peer.Send(m)
+	}
+	c.pending = nil
+	c.peer = peer
+	c.offline = false
+	c.err = nil
+	return nil
+}
@@ -0,0 +1,171 @@
+package stomp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept accepts the next connection and establishes
+	// the session.
+	accept := func() Peer {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer := Conn(conn)
+		m := <-peer.Receive()
+		if !bytes.Equal(m.Method, MethodStomp) {
+			t.Fatalf("Want stomp method, got %s", m.Method)
+		}
+		if !bytes.Equal(m.User, []byte("janedoe")) {
+			t.Errorf("Want connect options applied")
+		}
+		m = NewMessage()
+		m.Method = MethodConnected
+		m.Proto = STOMP
+		peer.Send(m)
+		return peer
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		client, err := DialReconnect("tcp://"+l.Addr().String(),
+			WithBackoff(time.Millisecond, time.Millisecond*10),
+			WithSendBuffer(1),
+		)
+		if err == nil {
+			err = client.Connect(WithCredentials("janedoe", "password"))
+		}
+		if err == nil {
+			_, err = client.Subscribe("/topic/test", HandlerFunc(func(*Message) {}))
+		}
+		errc <- err
+	}()
+
+	server := accept()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	sub := <-server.Receive()
+	if !bytes.Equal(sub.Method, MethodSubscribe) {
+		t.Fatalf("Want subscribe method, got %s", sub.Method)
+	}
+	id := string(sub.ID)
+
+	// terminate the connection and verify the client reconnects
+	// and restores the subscription.
+	server.Close()
+	server = accept()
+	defer server.Close()
+
+	select {
+	case sub := <-server.Receive():
+		if !bytes.Equal(sub.Method, MethodSubscribe) {
+			t.Fatalf("Want subscribe method, got %s", sub.Method)
+		}
+		if string(sub.ID) != id || string(sub.Dest) != "/topic/test" {
+			t.Errorf("Want subscription restored with id %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want subscription restored after reconnect")
+	}
+}
+
+func TestDialReconnectDisconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept accepts the next connection and receives
+	// the stomp frame.
+	accept := func() Peer {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer := Conn(conn)
+		<-peer.Receive()
+		return peer
+	}
+	connected := func(peer Peer) {
+		m := NewMessage()
+		m.Method = MethodConnected
+		m.Proto = STOMP
+		peer.Send(m)
+	}
+
+	errc := make(chan error, 1)
+	clientc := make(chan *Client, 1)
+	go func() {
+		client, err := DialReconnect("tcp://"+l.Addr().String(),
+			WithBackoff(time.Millisecond, time.Millisecond*10),
+		)
+		if err == nil {
+			err = client.Connect()
+		}
+		clientc <- client
+		errc <- err
+	}()
+
+	server := accept()
+	connected(server)
+	client := <-clientc
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// terminate the connection, and disconnect the client
+	// while the session is re-established.
+	server.Close()
+	server = accept()
+	defer server.Close()
+	if err := client.Disconnect(); err != nil {
+		t.Errorf("Want client disconnected while reconnecting, got %s", err)
+	}
+	connected(server)
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Want client done when disconnected while reconnecting")
+	}
+	select {
+	case _, ok := <-server.Receive():
+		if ok {
+			t.Errorf("Want connection closed when disconnected while reconnecting")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want connection closed when disconnected while reconnecting")
+	}
+}
+
+func TestClientSendBuffer(t *testing.T) {
+	a, _ := Pipe()
+	client := New(a)
+	client.buffer = 1
+	client.offline = true
+
+	if err := client.Send("/queue/test", []byte("hello")); err != nil {
+		t.Errorf("Want message buffered while reconnecting, got %s", err)
+	}
+	if err := client.Send("/queue/test", []byte("hello")); err != ErrBufferFull {
+		t.Errorf("Want ErrBufferFull when the buffer is full, got %v", err)
+	}
+	if err := client.Ack([]byte("1")); err != ErrDisconnected {
+		t.Errorf("Want ErrDisconnected when acking while reconnecting, got %v", err)
+	}
+	if got := len(client.pending); got != 1 {
+		t.Errorf("Want 1 buffered message, got %d", got)
+	}
+}
@@ -0,0 +1,103 @@
+package stomp
+
+import (
+	"errors"
+
+	"github.com/drone/mq/logger"
+
+	"golang.org/x/net/context"
+)
+
+// ErrNoReplyTo is returned when replying to a message
+// that does not include a reply-to header.
+var ErrNoReplyTo = errors.New("stomp: message has no reply-to header")
+
+// tempQueue is the destination prefix of temporary queues. A temporary
+// queue is owned by the session that subscribes to it and is deleted
+// when the session disconnects.
+const tempQueue = "/temp-queue/"
+
+// Request sends the data to the given destination and waits for the reply,
+// or until the context is canceled or times out. The request includes the
+// reply-to and correlation-id headers, which the responder uses to send
+// the reply. The caller is responsible for releasing the reply.
+func (c *Client) Request(ctx context.Context, dest string, data []byte, opts ...MessageOption) (*Message, error) {
+	replyTo, err := c.replyQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	id := string(Rand())
+	replyc := make(chan *Message, 1)
+	c.mu.Lock()
+	c.calls[id] = replyc
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+	}()
+
+	opts = append(opts,
+		WithReplyTo(replyTo),
+		WithCorrelationID(id),
+	)
+	if err := c.SendContext(ctx, dest, data, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m := <-replyc:
+		return m, nil
+	}
+}
+
+// Reply sends the data to the reply-to destination of the given
+// message, including the correlation-id of the message.
+func (c *Client) Reply(m *Message, data []byte, opts ...MessageOption) error {
+	dest := m.Header.Get(HeaderReplyTo)
+	if len(dest) == 0 {
+		return ErrNoReplyTo
+	}
+	if id := m.Header.Get(HeaderCorrelation); len(id) != 0 {
+		opts = append(opts, WithCorrelationID(string(id)))
+	}
+	return c.Send(string(dest), data, opts...)
+}
+
+// replyQueue returns the temporary queue that receives replies,
+// subscribing to the queue if this is the first request.
+func (c *Client) replyQueue() (string, error) {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+
+	if c.replyTo != "" {
+		return c.replyTo, nil
+	}
+	dest := tempQueue + string(Rand())
+	if _, err := c.Subscribe(dest, HandlerFunc(c.handleReply)); err != nil {
+		return "", err
+	}
+	c.replyTo = dest
+	return dest, nil
+}
+
+// handleReply delivers the reply to the pending request
+// with the matching correlation id.
+func (c *Client) handleReply(m *Message) {
+	id := m.Header.Get(HeaderCorrelation)
+
+	c.mu.Lock()
+	replyc, ok := c.calls[string(id)]
+	delete(c.calls, string(id))
+	c.mu.Unlock()
+	if !ok {
+		logger.Noticef("stomp client: request not found: %s", string(id))
+		m.Release()
+		return
+	}
+	replyc <- m
+}
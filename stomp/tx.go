@@ -0,0 +1,71 @@
+package stomp
+
+// Tx represents a transaction. Messages sent and acknowledged using
+// the transaction are applied by the server when the transaction is
+// committed, or discarded when it is aborted.
+type Tx struct {
+	id     []byte
+	client *Client
+}
+
+// Begin starts a new transaction.
+func (c *Client) Begin(opts ...MessageOption) (*Tx, error) {
+	tx := &Tx{
+		id:     append([]byte("tx-"), c.incr()...),
+		client: c,
+	}
+
+	m := NewMessage()
+	m.Method = MethodBegin
+	m.Tx = tx.id
+	m.Apply(opts...)
+
+	if err := c.sendMessage(m); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// ID returns the transaction id.
+func (t *Tx) ID() []byte {
+	return t.id
+}
+
+// Send sends the data to the given destination as part of the transaction.
+func (t *Tx) Send(dest string, data []byte, opts ...MessageOption) error {
+	return t.client.Send(dest, data, append(opts, t.enlist)...)
+}
+
+// Ack acknowledges the message with the given id as part of the transaction.
+func (t *Tx) Ack(id []byte, opts ...MessageOption) error {
+	return t.client.Ack(id, append(opts, t.enlist)...)
+}
+
+// Nack negative-acknowledges the message with the given id as part of
+// the transaction.
+func (t *Tx) Nack(id []byte, opts ...MessageOption) error {
+	return t.client.Nack(id, append(opts, t.enlist)...)
+}
+
+// Commit commits the transaction.
+func (t *Tx) Commit(opts ...MessageOption) error {
+	return t.end(MethodCommit, opts)
+}
+
+// Abort aborts the transaction.
+func (t *Tx) Abort(opts ...MessageOption) error {
+	return t.end(MethodAbort, opts)
+}
+
+func (t *Tx) end(method []byte, opts []MessageOption) error {
+	m := NewMessage()
+	m.Method = method
+	m.Tx = t.id
+	m.Apply(opts...)
+	return t.client.sendMessage(m)
+}
+
+// enlist is a MessageOption that adds the message to the transaction.
+func (t *Tx) enlist(m *Message) {
+	m.Tx = t.id
+}
@@ -0,0 +1,105 @@
+package stomp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/drone/mq/logger"
+
+	"golang.org/x/net/context"
+)
+
+// headerConcurrency is a client-side subscribe header that configures
+// the subscription worker pool. It is removed before the subscribe
+// frame is sent to the server.
+var headerConcurrency = []byte("concurrency")
+
+// drainTimeout bounds the time spent waiting for the workers to handle
+// the queued messages if the context has no deadline. A pool closed by
+// its own handler cannot drain until the handler returns.
+var drainTimeout = time.Second * 5
+
+// workerPool is a bounded pool of workers that invoke the subscription
+// handler. With a single worker messages are handled in order.
+type workerPool struct {
+	wg   sync.WaitGroup
+	work chan *Message
+	quit chan struct{}
+	once sync.Once
+}
+
+// newWorkerPool returns a pool of n workers invoking the handler.
+func newWorkerPool(n int, handler Handler) *workerPool {
+	p := &workerPool{
+		work: make(chan *Message, n),
+		quit: make(chan struct{}),
+	}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case m := <-p.work:
+					handler.Handle(m)
+				case <-p.quit:
+					// the queued messages are handled
+					// before the worker exits.
+					for {
+						select {
+						case m := <-p.work:
+							handler.Handle(m)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// handle queues the message for the next available worker, blocking
+// while all workers are busy and the queue is full. If the pool is
+// closed the message is discarded.
+func (p *workerPool) handle(m *Message) {
+	select {
+	case <-p.quit:
+		m.Release()
+		return
+	default:
+	}
+	select {
+	case p.work <- m:
+	case <-p.quit:
+		m.Release()
+	}
+}
+
+// close stops the pool and waits for the workers to handle the queued
+// messages, until the context is done or the drain timeout is exceeded.
+func (p *workerPool) close(ctx context.Context) error {
+	p.once.Do(func() {
+		close(p.quit)
+	})
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, drainTimeout)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		logger.Warningf("stomp client: cannot drain subscription workers. %s", ctx.Err())
+		return ctx.Err()
+	}
+}
@@ -3,6 +3,7 @@ package stomp
 import (
 	"bytes"
 	"io"
+	"strconv"
 )
 
 var (
@@ -10,9 +11,21 @@ var (
 	newline    = []byte{'\n'}
 	separator  = []byte{':'}
 	terminator = []byte{0}
+
+	escapeCR    = []byte(`\r`)
+	escapeLF    = []byte(`\n`)
+	escapeColon = []byte(`\c`)
+	escapeSlash = []byte(`\\`)
 )
 
 func writeTo(w io.Writer, m *Message) {
+	// header values are escaped, except in the connect and
+	// connected frames for backward compatibility.
+	value := writeEscaped
+	if !escapeHeaders(m) {
+		value = writeRaw
+	}
+
 	w.Write(m.Method)
 	w.Write(newline)
 
@@ -37,113 +50,143 @@ func writeTo(w io.Writer, m *Message) {
 			w.Write(m.Pass)
 			w.Write(newline)
 		}
+		// heart-beat
+		if len(m.Heartbeat) != 0 {
+			w.Write(HeaderHeartbeat)
+			w.Write(separator)
+			w.Write(m.Heartbeat)
+			w.Write(newline)
+		}
 	case bytes.Equal(m.Method, MethodConnected):
 		// version
 		w.Write(HeaderVersion)
 		w.Write(separator)
 		w.Write(m.Proto)
 		w.Write(newline)
+		// heart-beat
+		if len(m.Heartbeat) != 0 {
+			w.Write(HeaderHeartbeat)
+			w.Write(separator)
+			w.Write(m.Heartbeat)
+			w.Write(newline)
+		}
 	case bytes.Equal(m.Method, MethodSend):
 		// dest
 		w.Write(HeaderDest)
 		w.Write(separator)
-		w.Write(m.Dest)
+		value(w, m.Dest)
 		w.Write(newline)
 		if len(m.Expires) != 0 {
 			w.Write(HeaderExpires)
 			w.Write(separator)
-			w.Write(m.Expires)
+			value(w, m.Expires)
 			w.Write(newline)
 		}
 		if len(m.Retain) != 0 {
 			w.Write(HeaderRetain)
 			w.Write(separator)
-			w.Write(m.Retain)
+			value(w, m.Retain)
 			w.Write(newline)
 		}
 		if len(m.Persist) != 0 {
 			w.Write(HeaderPersist)
 			w.Write(separator)
-			w.Write(m.Persist)
+			value(w, m.Persist)
 			w.Write(newline)
 		}
 	case bytes.Equal(m.Method, MethodSubscribe):
 		// id
 		w.Write(HeaderID)
 		w.Write(separator)
-		w.Write(m.ID)
+		value(w, m.ID)
 		w.Write(newline)
 		// destination
 		w.Write(HeaderDest)
 		w.Write(separator)
-		w.Write(m.Dest)
+		value(w, m.Dest)
 		w.Write(newline)
 		// selector
 		if len(m.Selector) != 0 {
 			w.Write(HeaderSelector)
 			w.Write(separator)
-			w.Write(m.Selector)
+			value(w, m.Selector)
 			w.Write(newline)
 		}
 		// prefetch
 		if len(m.Prefetch) != 0 {
 			w.Write(HeaderPrefetch)
 			w.Write(separator)
-			w.Write(m.Prefetch)
+			value(w, m.Prefetch)
 			w.Write(newline)
 		}
 		if len(m.Ack) != 0 {
 			w.Write(HeaderAck)
 			w.Write(separator)
-			w.Write(m.Ack)
+			value(w, m.Ack)
 			w.Write(newline)
 		}
 	case bytes.Equal(m.Method, MethodUnsubscribe):
 		// id
 		w.Write(HeaderID)
 		w.Write(separator)
-		w.Write(m.ID)
+		value(w, m.ID)
 		w.Write(newline)
 	case bytes.Equal(m.Method, MethodAck):
 		// id
 		w.Write(HeaderID)
 		w.Write(separator)
-		w.Write(m.ID)
+		value(w, m.ID)
 		w.Write(newline)
 	case bytes.Equal(m.Method, MethodNack):
 		// id
 		w.Write(HeaderID)
 		w.Write(separator)
-		w.Write(m.ID)
+		value(w, m.ID)
 		w.Write(newline)
 	case bytes.Equal(m.Method, MethodMessage):
 		// message-id
 		w.Write(HeaderMessageID)
 		w.Write(separator)
-		w.Write(m.ID)
+		value(w, m.ID)
 		w.Write(newline)
 		// destination
 		w.Write(HeaderDest)
 		w.Write(separator)
-		w.Write(m.Dest)
+		value(w, m.Dest)
 		w.Write(newline)
 		// subscription
 		w.Write(HeaderSubscription)
 		w.Write(separator)
-		w.Write(m.Subs)
+		value(w, m.Subs)
 		w.Write(newline)
 		// ack
 		if len(m.Ack) != 0 {
 			w.Write(HeaderAck)
 			w.Write(separator)
-			w.Write(m.Ack)
+			value(w, m.Ack)
 			w.Write(newline)
 		}
 	case bytes.Equal(m.Method, MethodRecipet):
 		// receipt-id
 		w.Write(HeaderReceiptID)
 		w.Write(separator)
-		w.Write(m.Receipt)
+		value(w, m.Receipt)
+		w.Write(newline)
+	case bytes.Equal(m.Method, MethodError):
+		// receipt-id
+		if len(m.Receipt) != 0 {
+			w.Write(HeaderReceiptID)
+			w.Write(separator)
+			value(w, m.Receipt)
+			w.Write(newline)
+		}
+	}
+
+	// transaction header
+	if len(m.Tx) != 0 {
+		w.Write(HeaderTransaction)
+		w.Write(separator)
+		value(w, m.Tx)
 		w.Write(newline)
 	}
 
@@ -151,7 +194,7 @@ func writeTo(w io.Writer, m *Message) {
 	if includeReceiptHeader(m) {
 		w.Write(HeaderReceipt)
 		w.Write(separator)
-		w.Write(m.Receipt)
+		value(w, m.Receipt)
 		w.Write(newline)
 	}
 
@@ -159,9 +202,16 @@ func writeTo(w io.Writer, m *Message) {
 		if m.Header.itemc == i {
 			break
 		}
-		w.Write(item.name)
+		value(w, item.name)
 		w.Write(separator)
-		w.Write(item.data)
+		value(w, item.data)
+		w.Write(newline)
+	}
+	// content-length header
+	if len(m.Body) != 0 {
+		w.Write(HeaderContentLen)
+		w.Write(separator)
+		w.Write(strconv.AppendInt(make([]byte, 0, 20), int64(len(m.Body)), 10))
 		w.Write(newline)
 	}
 	w.Write(newline)
@@ -169,5 +219,42 @@ func writeTo(w io.Writer, m *Message) {
 }
 
 func includeReceiptHeader(m *Message) bool {
-	return len(m.Receipt) != 0 && !bytes.Equal(m.Method, MethodRecipet)
+	return len(m.Receipt) != 0 &&
+		!bytes.Equal(m.Method, MethodRecipet) &&
+		!bytes.Equal(m.Method, MethodError)
+}
+
+func escapeHeaders(m *Message) bool {
+	return !bytes.Equal(m.Method, MethodStomp) &&
+		!bytes.Equal(m.Method, MethodConnect) &&
+		!bytes.Equal(m.Method, MethodConnected)
+}
+
+func writeRaw(w io.Writer, b []byte) {
+	w.Write(b)
+}
+
+// writeEscaped writes the header octets, escaping the carriage
+// return, line feed, colon and backslash characters.
+func writeEscaped(w io.Writer, b []byte) {
+	var pos int
+	for i, c := range b {
+		var esc []byte
+		switch c {
+		case '\r':
+			esc = escapeCR
+		case '\n':
+			esc = escapeLF
+		case ':':
+			esc = escapeColon
+		case '\\':
+			esc = escapeSlash
+		default:
+			continue
+		}
+		w.Write(b[pos:i])
+		w.Write(esc)
+		pos = i + 1
+	}
+	w.Write(b[pos:])
 }
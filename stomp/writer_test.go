@@ -19,6 +19,24 @@ var payloads = []struct {
 			Header: newHeader(),
 		},
 	},
+	{
+		payload: "STOMP\naccept-version:1.2\nheart-beat:1000,2000\n\n",
+		message: &Message{
+			Method:    MethodStomp,
+			Proto:     STOMP,
+			Heartbeat: []byte("1000,2000"),
+			Header:    newHeader(),
+		},
+	},
+	{
+		payload: "CONNECTED\nversion:1.2\nheart-beat:1000,2000\n\n",
+		message: &Message{
+			Method:    MethodConnected,
+			Proto:     STOMP,
+			Heartbeat: []byte("1000,2000"),
+			Header:    newHeader(),
+		},
+	},
 	{
 		payload: "CONNECTED\nversion:1.2\n\n",
 		message: &Message{
@@ -28,7 +46,7 @@ var payloads = []struct {
 		},
 	},
 	{
-		payload: "SEND\ndestination:/queue/test\nexpires:1234\nretain:all\npersist:true\nreceipt:4321\n\nhello",
+		payload: "SEND\ndestination:/queue/test\nexpires:1234\nretain:all\npersist:true\nreceipt:4321\ncontent-length:5\n\nhello",
 		message: &Message{
 			Method:  MethodSend,
 			Dest:    []byte("/queue/test"),
@@ -77,7 +95,7 @@ var payloads = []struct {
 		},
 	},
 	{
-		payload: "MESSAGE\nmessage-id:123\ndestination:/queue/test\nsubscription:321\nack:312\n\nhello",
+		payload: "MESSAGE\nmessage-id:123\ndestination:/queue/test\nsubscription:321\nack:312\ncontent-length:5\n\nhello",
 		message: &Message{
 			Method: MethodMessage,
 			Dest:   []byte("/queue/test"),
@@ -95,6 +113,19 @@ var payloads = []struct {
 			Header: newHeader(),
 		},
 	},
+	{
+		payload: "ERROR\nreceipt-id:123\nmessage:stomp\\c not authorized\ncontent-length:5\n\nSEND\n",
+		message: &Message{
+			Method:  MethodError,
+			Receipt: []byte("123"),
+			Body:    []byte("SEND\n"),
+			Header: func() *Header {
+				header := newHeader()
+				header.Add([]byte("message"), []byte("stomp: not authorized"))
+				return header
+			}(),
+		},
+	},
 	{
 		payload: "RECEIPT\nreceipt-id:123\n\n",
 		message: &Message{
@@ -115,6 +146,65 @@ var payloads = []struct {
 			}(),
 		},
 	},
+	{
+		payload: "BEGIN\ntransaction:tx1\n\n",
+		message: &Message{
+			Method: MethodBegin,
+			Tx:     []byte("tx1"),
+			Header: newHeader(),
+		},
+	},
+	{
+		payload: "ACK\nid:123\ntransaction:tx1\n\n",
+		message: &Message{
+			Method: MethodAck,
+			ID:     []byte("123"),
+			Tx:     []byte("tx1"),
+			Header: newHeader(),
+		},
+	},
+	{
+		payload: "COMMIT\ntransaction:tx1\nreceipt:123\n\n",
+		message: &Message{
+			Method:  MethodCommit,
+			Tx:      []byte("tx1"),
+			Receipt: []byte("123"),
+			Header:  newHeader(),
+		},
+	},
+	{
+		payload: "SEND\ndestination:/queue/test\ncontent-length:5\n\nhe\x00lo",
+		message: &Message{
+			Method: MethodSend,
+			Dest:   []byte("/queue/test"),
+			Body:   []byte("he\x00lo"),
+			Header: newHeader(),
+		},
+	},
+	{
+		payload: "MESSAGE\nmessage-id:123\ndestination:/queue/a\\cb\nsubscription:321\nfoo\\c\\\\bar:line\\none\\rline\\ntwo\n\n",
+		message: &Message{
+			Method: MethodMessage,
+			Dest:   []byte("/queue/a:b"),
+			ID:     []byte("123"),
+			Subs:   []byte("321"),
+			Header: func() *Header {
+				header := newHeader()
+				header.Add([]byte("foo:\\bar"), []byte("line\none\rline\ntwo"))
+				return header
+			}(),
+		},
+	},
+	{
+		payload: "STOMP\naccept-version:1.2\nlogin:jane:doe\npasscode:pa\\55word\n\n",
+		message: &Message{
+			Method: MethodStomp,
+			Proto:  STOMP,
+			User:   []byte("jane:doe"),
+			Pass:   []byte("pa\\55word"),
+			Header: newHeader(),
+		},
+	},
 	{
 		payload: "DISCONNECT\nreceipt:123\n\n",
 		message: &Message{